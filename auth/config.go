@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"os"
+	"strconv"
+)
+
+type Config struct {
+	// JwksFile is the path to a JSON Web Key Set used to verify token signatures
+	JwksFile string
+	// KeyFile is the path to a PEM encoded public key used to verify token signatures
+	KeyFile string
+	// HmacSecret is a shared secret used to verify HS256 token signatures
+	HmacSecret string
+	Issuer     string
+	Audience   string
+	// AutoProvision creates a user from the token claims the first time an unknown auth id signs in
+	AutoProvision bool
+}
+
+// LoadEnvConfig reads authentication configuration from environment variables
+func LoadEnvConfig() Config {
+	return NewConfig(os.Getenv)
+}
+
+// NewConfig reads authentication configuration through getenv, e.g. the Get method of a resolved configuration
+func NewConfig(getenv func(key string) string) Config {
+	autoProvision, _ := strconv.ParseBool(getenv("AUTH_AUTO_PROVISION"))
+
+	return Config{
+		JwksFile:      getenv("AUTH_JWKS_FILE"),
+		KeyFile:       getenv("AUTH_KEY_FILE"),
+		HmacSecret:    getenv("AUTH_HMAC_SECRET"),
+		Issuer:        getenv("AUTH_ISSUER"),
+		Audience:      getenv("AUTH_AUDIENCE"),
+		AutoProvision: autoProvision,
+	}
+}
+
+// Configured reports whether a token verification key is configured, without which no token can be authenticated
+func (c Config) Configured() bool {
+	return c.JwksFile != "" || c.KeyFile != "" || c.HmacSecret != ""
+}
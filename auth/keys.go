@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"math/big"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet resolves the key that verifies a token
+type keySet struct {
+	byKid  map[string]interface{}
+	static interface{}
+	hmac   []byte
+}
+
+func loadKeySet(cfg Config) (*keySet, error) {
+	ks := &keySet{byKid: map[string]interface{}{}}
+
+	if cfg.JwksFile != "" {
+		b, err := ioutil.ReadFile(cfg.JwksFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwks file: %w", err)
+		}
+
+		keys, err := parseJwks(b)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ks.byKid = keys
+	}
+
+	if cfg.KeyFile != "" {
+		b, err := ioutil.ReadFile(cfg.KeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+
+		key, err := parsePublicKeyPEM(b)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ks.static = key
+	}
+
+	if cfg.HmacSecret != "" {
+		ks.hmac = []byte(cfg.HmacSecret)
+	}
+
+	if len(ks.byKid) == 0 && ks.static == nil && ks.hmac == nil {
+		return nil, errors.New("no token verification key is configured")
+	}
+
+	return ks, nil
+}
+
+// keyfunc returns the verification key for a token, rejecting keys that do not match the signing method
+func (ks *keySet) keyfunc(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if ks.hmac == nil {
+			return nil, errors.New("hmac signed tokens are not accepted")
+		}
+
+		return ks.hmac, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if kid, ok := t.Header["kid"].(string); ok {
+			if key, ok := ks.byKid[kid]; ok {
+				return key, nil
+			}
+		}
+
+		if ks.static != nil {
+			return ks.static, nil
+		}
+
+		return nil, errors.New("no key found for token")
+	}
+
+	return nil, fmt.Errorf("unsupported signing method %s", t.Method.Alg())
+}
+
+func parseJwks(b []byte) (map[string]interface{}, error) {
+	var set jwks
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwk %q: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parsePublicKeyPEM(b []byte) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseECPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("key file does not contain an RSA or EC public key")
+}
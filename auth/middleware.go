@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strings"
+)
+
+const ErrMissingToken = "missing bearer token"
+const ErrInvalidToken = "invalid token"
+const ErrUnknownUser = "user does not exist"
+const ErrProvisionFailed = "user cannot be provisioned from the token claims"
+
+// unauthenticated creates an error of a token that does not authenticate a user
+func unauthenticated(msg string) error {
+	return &data.Error{Code: data.CodeUnauthenticated, Msg: msg}
+}
+
+// UserStore is the subset of data.Store used to resolve token subjects to users
+type UserStore interface {
+	GetUserByAuthId(authId string) (*data.User, error)
+	CreateUser(u *data.User) (*data.User, error)
+}
+
+// Claims are the token claims consumed by the service.
+// The subject is the user's AuthId
+type Claims struct {
+	jwt.RegisteredClaims
+	Email      string `json:"email"`
+	GivenName  string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+}
+
+// Identity is the authenticated user of a request
+type Identity struct {
+	User   *data.User
+	Claims *Claims
+}
+
+type Authenticator struct {
+	cfg   Config
+	keys  *keySet
+	users UserStore
+}
+
+// NewAuthenticator creates an Authenticator that verifies tokens with the configured keys and resolves users from a UserStore
+func NewAuthenticator(cfg Config, users UserStore) (*Authenticator, error) {
+	keys, err := loadKeySet(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		cfg:   cfg,
+		keys:  keys,
+		users: users,
+	}, nil
+}
+
+// Authenticate validates a raw token and resolves the user it belongs to.
+// If auto provisioning is enabled, a user is created from the token claims when none exists for the subject
+func (a *Authenticator) Authenticate(token string) (*Identity, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, a.keys.keyfunc)
+
+	if err != nil {
+		return nil, unauthenticated(ErrInvalidToken)
+	}
+
+	if a.cfg.Issuer != "" && !claims.VerifyIssuer(a.cfg.Issuer, true) {
+		return nil, unauthenticated(ErrInvalidToken)
+	}
+
+	if a.cfg.Audience != "" && !claims.VerifyAudience(a.cfg.Audience, true) {
+		return nil, unauthenticated(ErrInvalidToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, unauthenticated(ErrInvalidToken)
+	}
+
+	u, err := a.users.GetUserByAuthId(claims.Subject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		if !a.cfg.AutoProvision {
+			return nil, unauthenticated(ErrUnknownUser)
+		}
+
+		u, err = a.users.CreateUser(&data.User{
+			AuthId:    claims.Subject,
+			Email:     claims.Email,
+			FirstName: claims.GivenName,
+			LastName:  claims.FamilyName,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Identity{User: u, Claims: claims}, nil
+}
+
+// Middleware authenticates the bearer token of each request and injects the resulting Identity into the request context,
+// along with a logger that tags entries with the user's id and the user as the actor of the stores bound to the context.
+// Requests without a valid token are rejected with 401 Unauthorized,
+// requests whose user cannot be provisioned from the token claims with 403 Forbidden.
+// The service only serves http, so there is no gRPC interceptor
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+
+		if token == "" {
+			http.Error(w, ErrMissingToken, http.StatusUnauthorized)
+			return
+		}
+
+		identity, err := a.Authenticate(token)
+
+		if err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+
+		ctx := WithIdentity(r.Context(), identity)
+		ctx = logging.WithUser(ctx, identity.User.Id)
+		ctx = data.ContextWithActor(ctx, identity.User.Id)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// writeAuthError rejects a request that failed to authenticate. Only the messages of this package are sent to the client,
+// the details of other errors are logged
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, data.ErrValidation), errors.Is(err, data.ErrConflict):
+		// the token is valid, but its claims do not make a valid user, e.g. because the email claim is missing
+		logging.FromContext(r.Context()).WithError(err).Warn("failed to provision user")
+		http.Error(w, ErrProvisionFailed, http.StatusForbidden)
+	default:
+		logging.FromContext(r.Context()).WithError(err).Error("failed to authenticate request")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(h[7:])
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx that carries an Identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the Identity carried by ctx, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeUserStore struct {
+	users     map[string]*data.User
+	createErr error
+}
+
+func (f *fakeUserStore) GetUserByAuthId(authId string) (*data.User, error) {
+	return f.users[authId], nil
+}
+
+func (f *fakeUserStore) CreateUser(u *data.User) (*data.User, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+
+	u.Id = "00000000-0000-0000-0000-00000000000f"
+	f.users[u.AuthId] = u
+	return u, nil
+}
+
+const knownAuthId = "00000000-0000-0000-0000-000000000001"
+const unknownAuthId = "00000000-0000-0000-0000-000000000002"
+
+func newFakeUserStore() *fakeUserStore {
+	return &fakeUserStore{users: map[string]*data.User{
+		knownAuthId: {Id: "00000000-0000-0000-0000-000000000000", AuthId: knownAuthId},
+	}}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	s, err := token.SignedString(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func claimsFor(sub string) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   sub,
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"xtenancy"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Email:      "new@user.com",
+		GivenName:  "new",
+		FamilyName: "user",
+	}
+}
+
+func TestAuthenticateHmac(t *testing.T) {
+	a, err := NewAuthenticator(Config{HmacSecret: "secret", Issuer: "issuer", Audience: "xtenancy"}, newFakeUserStore())
+	assert.Nil(t, err)
+
+	identity, err := a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claimsFor(knownAuthId)))
+	assert.Nil(t, err)
+	assert.Equal(t, knownAuthId, identity.User.AuthId)
+
+	// wrong secret
+	_, err = a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("other"), "", claimsFor(knownAuthId)))
+	assert.Equal(t, ErrInvalidToken, err.Error())
+
+	// wrong audience
+	c := claimsFor(knownAuthId)
+	c.Audience = jwt.ClaimStrings{"other"}
+	_, err = a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", c))
+	assert.Equal(t, ErrInvalidToken, err.Error())
+
+	// expired
+	c = claimsFor(knownAuthId)
+	c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	_, err = a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", c))
+	assert.Equal(t, ErrInvalidToken, err.Error())
+
+	// unknown user without auto provisioning
+	_, err = a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claimsFor(unknownAuthId)))
+	assert.Equal(t, ErrUnknownUser, err.Error())
+}
+
+func TestAuthenticateJwksAutoProvision(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	set := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	b, _ := json.Marshal(set)
+
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	_ = ioutil.WriteFile(path, b, 0600)
+
+	store := newFakeUserStore()
+	a, err := NewAuthenticator(Config{JwksFile: path, AutoProvision: true}, store)
+	assert.Nil(t, err)
+
+	identity, err := a.Authenticate(sign(t, jwt.SigningMethodRS256, key, "key1", claimsFor(unknownAuthId)))
+	assert.Nil(t, err)
+	expected := &data.User{
+		Id:        "00000000-0000-0000-0000-00000000000f",
+		AuthId:    unknownAuthId,
+		Email:     "new@user.com",
+		FirstName: "new",
+		LastName:  "user",
+	}
+	assert.Equal(t, expected, identity.User)
+	assert.Equal(t, expected, store.users[unknownAuthId])
+
+	// hmac tokens are rejected when no secret is configured
+	_, err = a.Authenticate(sign(t, jwt.SigningMethodHS256, []byte("secret"), "key1", claimsFor(knownAuthId)))
+	assert.Equal(t, ErrInvalidToken, err.Error())
+}
+
+func TestMiddleware(t *testing.T) {
+	a, _ := NewAuthenticator(Config{HmacSecret: "secret"}, newFakeUserStore())
+
+	var identity *Identity
+	var logger logrus.FieldLogger
+	var actor string
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+		logger = logging.FromContext(r.Context())
+		actor, _ = data.ActorFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claimsFor(knownAuthId)))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, knownAuthId, identity.User.AuthId)
+	assert.Equal(t, identity.User.Id, logger.(*logrus.Entry).Data[logging.FieldUserId])
+	assert.Equal(t, identity.User.Id, actor)
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	store := newFakeUserStore()
+	a, _ := NewAuthenticator(Config{HmacSecret: "secret", AutoProvision: true}, store)
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("not a token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrInvalidToken+"\n", w.Body.String())
+
+	// claims that fail validation do not leak the validator's message
+	store.createErr = &data.Error{Code: data.CodeValidation, Msg: "email is a required field"}
+	w = serve(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claimsFor(unknownAuthId)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrProvisionFailed+"\n", w.Body.String())
+
+	store.createErr = errors.New("dial tcp 10.0.0.1:5432: connection refused")
+	w = serve(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claimsFor(unknownAuthId)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "10.0.0.1")
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"github.com/brietsparks/xtenancy/config"
+	"github.com/urfave/cli"
+)
+
+// setting is a resolved configuration value and the source that set it
+type setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// NewConfigCommand returns a command tree that inspects the resolved configuration
+func NewConfigCommand(name string, chConfig chan *config.Config) cli.Command {
+	var cfg *config.Config
+	var format string
+
+	return cli.Command{
+		Name:  name,
+		Usage: "inspect the configuration",
+		Flags: []cli.Flag{formatFlag(&format)},
+		Before: func(c *cli.Context) error {
+			cfg = <-chConfig
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "print",
+				Usage: "print the resolved configuration and where each value came from, with secrets redacted",
+				Action: func(c *cli.Context) error {
+					var settings []*setting
+
+					for _, k := range cfg.Keys() {
+						settings = append(settings, &setting{
+							Key:    k,
+							Value:  cfg.Redacted(k),
+							Source: cfg.Source(k),
+						})
+					}
+
+					return printRecords(format, settings)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+)
+
+// NewDoctorCommand returns a command that runs the readiness checks of the http server and explains their outcome
+func NewDoctorCommand(name string, chVars chan data.Vars) cli.Command {
+	var vars data.Vars
+
+	return cli.Command{
+		Name:  name,
+		Usage: "check that the database is reachable and its schema is up to date",
+		Before: func(c *cli.Context) error {
+			vars = <-chVars
+			return nil
+		},
+		Action: func(c *cli.Context) error {
+			d, err := data.OpenDb(vars)
+
+			if err != nil {
+				return err
+			}
+
+			defer d.Close()
+
+			report := health.Run(context.Background(), health.ReadinessChecks(d, vars))
+
+			return printReport(os.Stdout, report)
+		},
+	}
+}
+
+// printReport prints a line per check and fails if any check failed
+func printReport(w io.Writer, report *health.Report) error {
+	for _, r := range report.Checks {
+		if r.Ok {
+			fmt.Fprintf(w, "ok   %s\n", r.Name)
+			continue
+		}
+
+		fmt.Fprintf(w, "FAIL %s: %s\n", r.Name, r.Error)
+	}
+
+	if !report.Ok {
+		return errors.New("the service is not ready")
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPrintReport(t *testing.T) {
+	buf := &bytes.Buffer{}
+	report := &health.Report{
+		Ok: false,
+		Checks: []*health.Result{
+			{Name: "database", Ok: true},
+			{Name: "schema", Error: "version 3 does not match the expected version 4"},
+		},
+	}
+
+	assert.NotNil(t, printReport(buf, report))
+	assert.Equal(t, "ok   database\nFAIL schema: version 3 does not match the expected version 4\n", buf.String())
+
+	buf.Reset()
+	report.Ok = true
+	report.Checks = report.Checks[:1]
+	assert.Nil(t, printReport(buf, report))
+	assert.Equal(t, "ok   database\n", buf.String())
+}
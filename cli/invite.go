@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/urfave/cli"
+)
+
+// NewInviteCommand returns an invitation administration command tree that can be used by a urfave/cli instance
+func NewInviteCommand(name string, chVars chan data.Vars) cli.Command {
+	var store *data.Store
+	var format string
+
+	return cli.Command{
+		Name:  name,
+		Usage: "manage tenant invitations",
+		Flags: []cli.Flag{formatFlag(&format)},
+		Before: func(c *cli.Context) error {
+			s, err := openStore(<-chVars)
+			store = s
+			return err
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "send",
+				Usage:     "invite an email address to a tenant",
+				ArgsUsage: "<tenant id> <email>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "tenant id", "email")
+
+					if err != nil {
+						return err
+					}
+
+					jr, err := store.InviteByEmail(args[0], args[1])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, jr)
+				},
+			},
+			{
+				Name:      "accept",
+				Usage:     "accept an invitation on behalf of its user",
+				ArgsUsage: "<joinrequest id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "joinrequest id")
+
+					if err != nil {
+						return err
+					}
+
+					m, err := store.AcceptInvitationMember(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, m)
+				},
+			},
+			{
+				Name:      "revoke",
+				Usage:     "revoke an open invitation",
+				ArgsUsage: "<joinrequest id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "joinrequest id")
+
+					if err != nil {
+						return err
+					}
+
+					if err := store.RevokeInvitation(args[0]); err != nil {
+						return err
+					}
+
+					fmt.Printf("revoked invitation %s\n", args[0])
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/gocraft/dbr/v2"
+	"github.com/urfave/cli"
+)
+
+// NewMemberCommand returns a tenant membership administration command tree that can be used by a urfave/cli instance
+func NewMemberCommand(name string, chVars chan data.Vars) cli.Command {
+	var store *data.Store
+	var format string
+
+	// memberAction runs a store operation on the member given as the first argument
+	memberAction := func(op func(id string) error, done string) func(c *cli.Context) error {
+		return func(c *cli.Context) error {
+			args, err := requireArgs(c, "member id")
+
+			if err != nil {
+				return err
+			}
+
+			if err := op(args[0]); err != nil {
+				return err
+			}
+
+			fmt.Printf("%s member %s\n", done, args[0])
+			return nil
+		}
+	}
+
+	return cli.Command{
+		Name:  name,
+		Usage: "manage tenant members",
+		Flags: []cli.Flag{formatFlag(&format)},
+		Before: func(c *cli.Context) error {
+			s, err := openStore(<-chVars)
+			store = s
+			return err
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "list",
+				Usage:     "list the members of a tenant",
+				ArgsUsage: "<tenant id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "tenant id")
+
+					if err != nil {
+						return err
+					}
+
+					m, err := store.GetMembersByTenantId(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, m)
+				},
+			},
+			{
+				Name:      "update",
+				Usage:     "update the given fields of a member",
+				ArgsUsage: "<member id>",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "alias", Usage: "the member's alias in the tenant, removed if it is empty"},
+					cli.BoolTFlag{Name: "admin", Usage: "whether the member is an admin, e.g. --admin=false"},
+					cli.BoolTFlag{Name: "inactive", Usage: "whether the member is deactivated, e.g. --inactive=false"},
+					ifVersionFlag("member"),
+				},
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "member id")
+
+					if err != nil {
+						return err
+					}
+
+					m := &data.Member{
+						IsAdmin:    c.BoolT("admin"),
+						IsInactive: c.BoolT("inactive"),
+					}
+
+					if alias := c.String("alias"); alias != "" {
+						m.Alias = dbr.NewNullString(alias)
+					}
+
+					fields := setFields(c, map[string]string{
+						"alias":    "Alias",
+						"admin":    "IsAdmin",
+						"inactive": "IsInactive",
+					})
+
+					if err := store.UpdateMember(args[0], c.Int("if-version"), m, fields...); err != nil {
+						return err
+					}
+
+					m, err = store.GetMember(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, m)
+				},
+			},
+			{
+				Name:      "promote",
+				Usage:     "make a member an admin of their tenant",
+				ArgsUsage: "<member id>",
+				Action: memberAction(func(id string) error {
+					return store.PromoteMember(id)
+				}, "promoted"),
+			},
+			{
+				Name:      "demote",
+				Usage:     "revoke a member's admin rights",
+				ArgsUsage: "<member id>",
+				Action: memberAction(func(id string) error {
+					return store.DemoteMember(id)
+				}, "demoted"),
+			},
+			{
+				Name:      "deactivate",
+				Usage:     "deactivate a member",
+				ArgsUsage: "<member id>",
+				Action: memberAction(func(id string) error {
+					return store.DeactivateMember(id)
+				}, "deactivated"),
+			},
+		},
+	}
+}
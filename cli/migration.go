@@ -1,57 +1,773 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/brietsparks/xtenancy/data"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/urfave/cli"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// the directory that "create" scaffolds migrations into when no directory is given
+const defaultMigrationsDir = "data/migrations"
+
+var migrationNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
 // NewMigrateCommand returns a migration command tree that can be used by a urfave/cli instance
 func NewMigrateCommand(name string, chVars chan data.Vars) cli.Command {
 	var vars data.Vars
+	var db *sql.DB
 	var mig *migrate.Migrate
 
+	// migration lazily connects to the database so that subcommands like "create" work offline
+	migration := func() (*migrate.Migrate, error) {
+		if mig != nil {
+			return mig, nil
+		}
+
+		d, err := data.OpenDb(vars)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := data.NewSchemaMigration(d, vars.Name, vars.MigrationsDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		db = d
+		mig = m
+		return mig, nil
+	}
+
+	stepsFlag := cli.IntFlag{
+		Name:  "steps, n",
+		Usage: "apply at most `N` migrations instead of all of them",
+	}
+
+	yesFlag := cli.BoolFlag{
+		Name:  "yes, y",
+		Usage: "skip the confirmation prompt",
+	}
+
 	return cli.Command{
 		Name:  name,
 		Usage: "execute migration operations",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "Read migrations from `DIR` instead of the migrations embedded in the binary",
+			},
+		},
 		Before: func(c *cli.Context) error {
 			vars = <-chVars
 
-			url := data.MakeUrl(vars)
-			db, err := sql.Open("postgres", url)
-
-			if err != nil {
-				return err
-			}
-
-			m, err := data.NewSchemaMigration(db, vars.Name)
-
-			if err != nil {
-				return err
+			if dir := c.String("dir"); dir != "" {
+				vars.MigrationsDir = dir
 			}
 
-			mig = m
 			return nil
 		},
 		Subcommands: []cli.Command{
 			{
 				Name:  "up",
 				Usage: "execute migrations",
+				Flags: []cli.Flag{
+					stepsFlag,
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "print the SQL of pending migrations without executing it",
+					},
+					cli.BoolFlag{
+						Name:  "verify",
+						Usage: "apply pending migrations to a scratch schema inside a transaction that is rolled back",
+					},
+				},
 				Action: func(c *cli.Context) error {
+					steps := c.Int("steps")
+
+					if steps > 0 {
+						if err := refuseTenantSchemas(vars, "--steps"); err != nil {
+							return err
+						}
+					}
+
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					if c.Bool("dry-run") {
+						return printMigrationPlan(db, m, vars, steps)
+					}
+
+					if c.Bool("verify") {
+						return verifyMigrations(db, m, vars)
+					}
+
+					if steps > 0 {
+						fmt.Printf("migrating up %d step(s)...\n", steps)
+						return m.Steps(steps)
+					}
+
 					fmt.Println("migrating up...")
-					return mig.Up()
+
+					err = m.Up()
+
+					if err != nil && err != migrate.ErrNoChange {
+						return err
+					}
+
+					tenantErr := migrateTenantSchemas(db, vars, func(m *migrate.Migrate) error {
+						return m.Up()
+					})
+
+					if tenantErr != nil {
+						return tenantErr
+					}
+
+					return err
 				},
 			},
 			{
 				Name:  "down",
 				Usage: "rollback migrations",
+				Flags: []cli.Flag{stepsFlag, yesFlag},
 				Action: func(c *cli.Context) error {
+					steps := c.Int("steps")
+
+					if steps > 0 {
+						if err := refuseTenantSchemas(vars, "--steps"); err != nil {
+							return err
+						}
+					}
+
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					prompt := "Roll back ALL migrations? This drops every table and its data"
+					if steps > 0 {
+						prompt = fmt.Sprintf("Roll back %d migration(s)? This may drop tables and their data", steps)
+					}
+
+					if !c.Bool("yes") && !confirm(prompt) {
+						return errors.New("aborted")
+					}
+
+					if steps > 0 {
+						fmt.Printf("migrating down %d step(s)...\n", steps)
+						return m.Steps(-steps)
+					}
+
 					fmt.Println("migrating down...")
-					return mig.Down()
+
+					err = migrateTenantSchemas(db, vars, func(m *migrate.Migrate) error {
+						return m.Down()
+					})
+
+					if err != nil {
+						return err
+					}
+
+					return m.Down()
+				},
+			},
+			{
+				Name:      "goto",
+				Usage:     "migrate up or down to a version, tenant schemas to the last tenant migration up to that version",
+				ArgsUsage: "<version>",
+				Flags:     []cli.Flag{yesFlag},
+				Action: func(c *cli.Context) error {
+					target, err := versionArg(c)
+
+					if err != nil {
+						return err
+					}
+
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					current, _, err := m.Version()
+
+					if err != nil && err != migrate.ErrNilVersion {
+						return err
+					}
+
+					if target < current && !c.Bool("yes") && !confirm(fmt.Sprintf("Roll back from version %d to %d?", current, target)) {
+						return errors.New("aborted")
+					}
+
+					fmt.Printf("migrating to version %d...\n", target)
+					return migrateTo(db, m, vars, current, target)
+				},
+			},
+			{
+				Name:  "version",
+				Usage: "print the current migration version",
+				Action: func(c *cli.Context) error {
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					v, dirty, err := m.Version()
+
+					if err == migrate.ErrNilVersion {
+						fmt.Println("no migrations applied")
+						return nil
+					}
+
+					if err != nil {
+						return err
+					}
+
+					if dirty {
+						fmt.Printf("%d (dirty)\n", v)
+						return nil
+					}
+
+					fmt.Println(v)
+					return nil
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "list applied and pending migrations",
+				Action: func(c *cli.Context) error {
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					current, dirty, err := m.Version()
+
+					if err != nil && err != migrate.ErrNilVersion {
+						return err
+					}
+
+					files, err := data.ListMigrations(vars.MigrationsDir)
+
+					if err != nil {
+						return err
+					}
+
+					for _, f := range files {
+						status := "pending"
+
+						if f.Version < current || (f.Version == current && !dirty) {
+							status = "applied"
+						} else if f.Version == current && dirty {
+							status = "dirty"
+						}
+
+						fmt.Printf("%-8s %d %s\n", status, f.Version, f.Identifier)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "check",
+				Usage: "compare the live schema to the schema produced by the applied migrations",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "schema",
+						Usage: "the live `SCHEMA` to check",
+						Value: "public",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					current, err := appliedVersion(m)
+
+					if err != nil {
+						return err
+					}
+
+					drift, err := data.DetectSchemaDrift(db, vars.MigrationsDir, c.String("schema"), current)
+
+					if err != nil {
+						return err
+					}
+
+					if !drift.HasDrift() {
+						fmt.Printf("schema matches migrations at version %d\n", current)
+						return nil
+					}
+
+					for _, line := range drift.Missing {
+						fmt.Printf("- %s\n", line)
+					}
+
+					for _, line := range drift.Unexpected {
+						fmt.Printf("+ %s\n", line)
+					}
+
+					fmt.Println()
+					fmt.Println("- missing from the live schema, + not produced by migrations")
+
+					return fmt.Errorf("schema drift detected at version %d", current)
+				},
+			},
+			{
+				Name:  "lint",
+				Usage: "report migration operations that lock tables on a busy database",
+				Flags: []cli.Flag{
+					cli.UintFlag{
+						Name:  "after",
+						Usage: "only check migrations with a version greater than `VERSION`, e.g. the last released migration",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					findings, err := data.LintMigrations(vars.MigrationsDir, c.Uint("after"))
+
+					if err != nil {
+						return err
+					}
+
+					for _, f := range findings {
+						fmt.Println(f)
+					}
+
+					if len(findings) > 0 {
+						return fmt.Errorf("%d unsafe migration operation(s) found", len(findings))
+					}
+
+					fmt.Println("no unsafe migration operations found")
+					return nil
+				},
+			},
+			{
+				Name:      "force",
+				Usage:     "set the migration version without running migrations, used to recover from a dirty state",
+				ArgsUsage: "<version>",
+				Flags: []cli.Flag{
+					yesFlag,
+					cli.StringFlag{
+						Name:  "schema",
+						Usage: "force the version of the tenant `SCHEMA` instead of the public schema",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					v, err := versionArg(c)
+
+					if err != nil {
+						return err
+					}
+
+					schema := c.String("schema")
+
+					if !c.Bool("yes") && !confirm(fmt.Sprintf("Force the migration version of %s to %d?", schemaLabel(schema), v)) {
+						return errors.New("aborted")
+					}
+
+					fmt.Printf("forcing version %d...\n", v)
+
+					if schema != "" {
+						return data.MigrateTenantSchema(vars, schema, func(schema string, m *migrate.Migrate) error {
+							return m.Force(int(v))
+						})
+					}
+
+					m, err := migration()
+
+					if err != nil {
+						return err
+					}
+
+					return m.Force(int(v))
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "scaffold a new pair of timestamped up and down migration files",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "tenant",
+						Usage: "create the migration for tenant schemas",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					name := c.Args().First()
+
+					if !migrationNamePattern.MatchString(name) {
+						return errors.New("migration name must consist of lowercase letters, digits and underscores")
+					}
+
+					dir := vars.MigrationsDir
+
+					if dir == "" {
+						dir = defaultMigrationsDir
+					}
+
+					if c.Bool("tenant") {
+						dir = filepath.Join(dir, "tenant")
+					}
+
+					paths, err := createMigrationFiles(dir, name, time.Now())
+
+					if err != nil {
+						return err
+					}
+
+					for _, p := range paths {
+						fmt.Printf("created %s\n", p)
+					}
+
+					return nil
 				},
 			},
 		},
 	}
 }
+
+// migrateTenantSchemas applies a migration operation to every tenant schema when schema isolation is enabled
+func migrateTenantSchemas(db *sql.DB, vars data.Vars, op func(m *migrate.Migrate) error) error {
+	if vars.Isolation != data.IsolationSchema {
+		return nil
+	}
+
+	return data.MigrateTenantSchemas(db, vars, func(schema string, m *migrate.Migrate) error {
+		fmt.Printf("migrating tenant schema %s...\n", schema)
+
+		err := op(m)
+
+		if err == migrate.ErrNoChange {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// refuseTenantSchemas returns an error for an option that only migrates the public schema when schema isolation is enabled,
+// since the tenant schemas would be left at their version
+func refuseTenantSchemas(vars data.Vars, option string) error {
+	if vars.Isolation != data.IsolationSchema {
+		return nil
+	}
+
+	return fmt.Errorf("%s is not supported with schema isolation, since tenant schemas would not be migrated. Use goto instead", option)
+}
+
+// migrateTo migrates the public schema to a version, and with schema isolation every tenant schema to the last tenant
+// migration up to that version. Tenant schemas are migrated after the public schema on the way up and before it
+// on the way down, since their tables reference the public tables
+func migrateTo(db *sql.DB, m *migrate.Migrate, vars data.Vars, current uint, target uint) error {
+	files, err := data.ListTenantMigrations(vars.MigrationsDir)
+
+	if err != nil && vars.Isolation == data.IsolationSchema {
+		return err
+	}
+
+	tenants := func() error {
+		return migrateTenantSchemas(db, vars, func(m *migrate.Migrate) error {
+			if v, ok := tenantTarget(files, target); ok {
+				return m.Migrate(v)
+			}
+
+			return m.Down()
+		})
+	}
+
+	if target < current {
+		if err := tenants(); err != nil {
+			return err
+		}
+	}
+
+	if err := m.Migrate(target); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	if target < current {
+		return nil
+	}
+
+	return tenants()
+}
+
+// tenantTarget returns the version of the last tenant migration up to a version of the public schema.
+// It returns false if every tenant migration comes after the version
+func tenantTarget(files []data.MigrationFile, target uint) (uint, bool) {
+	var v uint
+	ok := false
+
+	for _, f := range files {
+		if f.Version <= target {
+			v = f.Version
+			ok = true
+		}
+	}
+
+	return v, ok
+}
+
+func schemaLabel(schema string) string {
+	if schema == "" {
+		return "the public schema"
+	}
+
+	return "tenant schema " + schema
+}
+
+// appliedVersion returns the current migration version, or 0 if no migrations are applied.
+// A dirty database is an error since it is unknown which statements of the current version were applied
+func appliedVersion(m *migrate.Migrate) (uint, error) {
+	v, dirty, err := m.Version()
+
+	if err == migrate.ErrNilVersion {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, fmt.Errorf("database is dirty at version %d, resolve it with force first", v)
+	}
+
+	return v, nil
+}
+
+func printMigrationPlan(db *sql.DB, m *migrate.Migrate, vars data.Vars, steps int) error {
+	current, err := appliedVersion(m)
+
+	if err != nil {
+		return err
+	}
+
+	scripts, err := data.ReadUpMigrations(vars.MigrationsDir, current)
+
+	if err != nil {
+		return err
+	}
+
+	if steps > 0 && steps < len(scripts) {
+		scripts = scripts[:steps]
+	}
+
+	for _, s := range scripts {
+		fmt.Printf("-- version %d: %s\n", s.Version, s.Identifier)
+		fmt.Println(strings.TrimSpace(s.Sql))
+		fmt.Println()
+	}
+
+	pending := len(scripts)
+
+	if vars.Isolation == data.IsolationSchema {
+		versions, err := data.TenantSchemaVersions(context.Background(), db)
+
+		if err != nil {
+			return err
+		}
+
+		for _, g := range groupSchemasByVersion(versions) {
+			scripts, err := data.ReadTenantUpMigrations(vars.MigrationsDir, g.version)
+
+			if err != nil {
+				return err
+			}
+
+			for _, s := range scripts {
+				fmt.Printf("-- tenant version %d: %s, for %s\n", s.Version, s.Identifier, strings.Join(g.schemas, ", "))
+				fmt.Println(strings.TrimSpace(s.Sql))
+				fmt.Println()
+			}
+
+			pending += len(scripts)
+		}
+	}
+
+	if pending == 0 {
+		fmt.Println("no pending migrations")
+	}
+
+	return nil
+}
+
+// schemaGroup is the tenant schemas at the same migration version
+type schemaGroup struct {
+	version uint
+	schemas []string
+}
+
+// groupSchemasByVersion groups tenant schemas by their migration version, in ascending version order
+func groupSchemasByVersion(versions map[string]uint) []schemaGroup {
+	bySchema := map[uint][]string{}
+
+	for schema, v := range versions {
+		bySchema[v] = append(bySchema[v], schema)
+	}
+
+	var groups []schemaGroup
+
+	for v, schemas := range bySchema {
+		sort.Strings(schemas)
+		groups = append(groups, schemaGroup{version: v, schemas: schemas})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].version < groups[j].version
+	})
+
+	return groups
+}
+
+func verifyMigrations(db *sql.DB, m *migrate.Migrate, vars data.Vars) error {
+	current, err := appliedVersion(m)
+
+	if err != nil {
+		return err
+	}
+
+	checks, err := data.VerifyMigrations(db, vars.MigrationsDir, current)
+
+	if err != nil {
+		return err
+	}
+
+	pending, err := reportChecks(checks, "")
+
+	if err != nil {
+		return err
+	}
+
+	if vars.Isolation == data.IsolationSchema {
+		versions, err := data.TenantSchemaVersions(context.Background(), db)
+
+		if err != nil {
+			return err
+		}
+
+		checks, err := data.VerifyTenantMigrations(db, vars.MigrationsDir, oldestVersion(versions))
+
+		if err != nil {
+			return err
+		}
+
+		tenantPending, err := reportChecks(checks, "tenant ")
+
+		if err != nil {
+			return err
+		}
+
+		pending += tenantPending
+	}
+
+	if pending == 0 {
+		fmt.Println("no pending migrations")
+	}
+
+	return nil
+}
+
+// reportChecks prints the pending migrations that passed verification and returns how many there are,
+// or an error for the first migration that failed
+func reportChecks(checks []data.MigrationCheck, label string) (int, error) {
+	pending := 0
+
+	for _, check := range checks {
+		if check.Err != nil {
+			fmt.Printf("FAIL %s%d %s: %s\n", label, check.Version, check.Identifier, check.Err)
+			return 0, fmt.Errorf("%smigration %d failed verification", label, check.Version)
+		}
+
+		if check.Pending {
+			pending++
+			fmt.Printf("ok   %s%d %s\n", label, check.Version, check.Identifier)
+		}
+	}
+
+	return pending, nil
+}
+
+// oldestVersion returns the lowest migration version of the tenant schemas, so that every migration
+// that one of them has not applied is pending. Without tenant schemas nothing is pending
+func oldestVersion(versions map[string]uint) uint {
+	oldest := ^uint(0)
+
+	for _, v := range versions {
+		if v < oldest {
+			oldest = v
+		}
+	}
+
+	return oldest
+}
+
+func createMigrationFiles(dir string, name string, now time.Time) ([]string, error) {
+	version := now.UTC().Format("20060102150405")
+
+	var paths []string
+
+	for _, direction := range []string{"up", "down"} {
+		p := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("migration file %s already exists", p)
+		}
+
+		if err := ioutil.WriteFile(p, []byte{}, 0644); err != nil {
+			return nil, fmt.Errorf("failed to create migration file: %w", err)
+		}
+
+		paths = append(paths, p)
+	}
+
+	return paths, nil
+}
+
+func versionArg(c *cli.Context) (uint, error) {
+	v, err := strconv.ParseUint(c.Args().First(), 10, 64)
+
+	if err != nil {
+		return 0, errors.New("a numeric migration version is required")
+	}
+
+	return uint(v), nil
+}
+
+// confirm asks the user a yes/no question on stdin
+func confirm(prompt string) bool {
+	return ask(os.Stdin, os.Stdout, prompt)
+}
+
+func ask(r io.Reader, w io.Writer, prompt string) bool {
+	_, _ = fmt.Fprintf(w, "%s [y/N]: ", prompt)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateMigrationFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.FixedZone("", 2*60*60))
+
+	paths, err := createMigrationFiles(dir, "add_widget", now)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "20261018103000_add_widget.up.sql"),
+		filepath.Join(dir, "20261018103000_add_widget.down.sql"),
+	}, paths)
+
+	for _, p := range paths {
+		content, err := ioutil.ReadFile(p)
+		assert.Nil(t, err)
+		assert.Empty(t, content)
+	}
+
+	_, err = createMigrationFiles(dir, "add_widget", now)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+}
+
+func TestVersionArg(t *testing.T) {
+	context := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("goto", flag.ContinueOnError)
+		assert.Nil(t, set.Parse(args))
+		return cli.NewContext(cli.NewApp(), set, nil)
+	}
+
+	v, err := versionArg(context("20261018120000"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(20261018120000), v)
+
+	for _, args := range [][]string{{}, {"latest"}, {"1.5"}} {
+		_, err = versionArg(context(args...))
+		assert.EqualError(t, err, "a numeric migration version is required")
+	}
+}
+
+func TestAsk(t *testing.T) {
+	for answer, expected := range map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		" y ":   true,
+		"n\n":   false,
+		"\n":    false,
+		"":      false,
+		"yep\n": false,
+	} {
+		out := &bytes.Buffer{}
+		assert.Equal(t, expected, ask(strings.NewReader(answer), out, "Roll back?"), answer)
+		assert.Equal(t, "Roll back? [y/N]: ", out.String())
+	}
+}
+
+func TestTenantTarget(t *testing.T) {
+	files := []data.MigrationFile{{Version: 10}, {Version: 20}, {Version: 30}}
+
+	for target, expected := range map[uint]uint{10: 10, 25: 20, 30: 30, 40: 30} {
+		v, ok := tenantTarget(files, target)
+		assert.True(t, ok)
+		assert.Equal(t, expected, v, target)
+	}
+
+	_, ok := tenantTarget(files, 5)
+	assert.False(t, ok)
+}
+
+func TestRefuseTenantSchemas(t *testing.T) {
+	assert.Nil(t, refuseTenantSchemas(data.Vars{Isolation: data.IsolationShared}, "--steps"))
+
+	err := refuseTenantSchemas(data.Vars{Isolation: data.IsolationSchema}, "--steps")
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "--steps is not supported with schema isolation"))
+}
+
+func TestGroupSchemasByVersion(t *testing.T) {
+	groups := groupSchemasByVersion(map[string]uint{"tenant_b": 20, "tenant_a": 20, "tenant_c": 10, "tenant_d": 0})
+
+	assert.Equal(t, []schemaGroup{
+		{version: 0, schemas: []string{"tenant_d"}},
+		{version: 10, schemas: []string{"tenant_c"}},
+		{version: 20, schemas: []string{"tenant_a", "tenant_b"}},
+	}, groups)
+
+	assert.Empty(t, groupSchemasByVersion(nil))
+}
+
+func TestOldestVersion(t *testing.T) {
+	assert.Equal(t, uint(20261018150000), oldestVersion(map[string]uint{"tenant_a": 20261018170000, "tenant_b": 20261018150000}))
+	assert.Equal(t, ^uint(0), oldestVersion(nil))
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// output formats of the admin commands
+const formatTable = "table"
+const formatJson = "json"
+
+func formatFlag(destination *string) cli.Flag {
+	return cli.StringFlag{
+		Name:        "format, f",
+		Usage:       "print results as `FORMAT`, either table or json",
+		Value:       formatTable,
+		Destination: destination,
+	}
+}
+
+// ifVersionFlag guards an update with the version of the record, so that concurrent changes are not overwritten
+func ifVersionFlag(resource string) cli.Flag {
+	return cli.IntFlag{
+		Name:  "if-version",
+		Usage: fmt.Sprintf("only update the %s if its version is `N`", resource),
+	}
+}
+
+// printRecords prints a record or a slice of records as a table or as json
+func printRecords(format string, records interface{}) error {
+	return writeRecords(os.Stdout, format, records)
+}
+
+func writeRecords(w io.Writer, format string, records interface{}) error {
+	switch format {
+	case formatJson:
+		b, err := json.MarshalIndent(records, "", "  ")
+
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case formatTable:
+		return writeTable(w, records)
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func writeTable(w io.Writer, records interface{}) error {
+	v := reflect.ValueOf(records)
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+
+		v = reflect.Append(reflect.MakeSlice(reflect.SliceOf(v.Type()), 0, 1), v)
+	}
+
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("cannot print %s as a table", v.Type())
+	}
+
+	t := v.Type().Elem()
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	var headers []string
+	var fields []int
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		headers = append(headers, name)
+		fields = append(fields, i)
+	}
+
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for i := 0; i < v.Len(); i++ {
+		record := reflect.Indirect(v.Index(i))
+
+		var cells []string
+
+		for _, f := range fields {
+			cells = append(cells, formatCell(record.Field(f).Interface()))
+		}
+
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func formatCell(value interface{}) string {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+
+		if err != nil || v == nil {
+			return ""
+		}
+
+		value = v
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ",")
+	case []byte:
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// requireArgs returns the positional arguments of a command, failing if fewer than the named arguments are given
+func requireArgs(c *cli.Context, names ...string) ([]string, error) {
+	args := c.Args()
+
+	if len(args) < len(names) {
+		return nil, fmt.Errorf("missing argument(s), expected: %s", strings.Join(names, " "))
+	}
+
+	return args[:len(names)], nil
+}
+
+// setFields maps the flags that were given on the command line to the model field names they update
+func setFields(c *cli.Context, flagFields map[string]string) []string {
+	var fields []string
+
+	for _, flag := range c.FlagNames() {
+		if field, ok := flagFields[flag]; ok && c.IsSet(flag) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
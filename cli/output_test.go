@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWriteRecords(t *testing.T) {
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	by := dbr.NewNullString("00000000-0000-0000-0000-000000000001")
+
+	tenants := []*data.Tenant{
+		{Id: "00000000-0000-0000-0000-000000000000", Name: "name0", OwnerId: "00000000-0000-0000-0000-000000000001", Version: 1, CreatedAt: at, UpdatedAt: at},
+		{Id: "00000000-0000-0000-0000-000000000002", Name: "name2", OwnerId: "00000000-0000-0000-0000-000000000001", ParentId: dbr.NewNullString("00000000-0000-0000-0000-000000000000"), Version: 2, CreatedAt: at, UpdatedAt: at, CreatedBy: by, UpdatedBy: by},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writeRecords(buf, formatTable, tenants))
+	expected := "" +
+		"id                                    name   ownerId                               parentId                              version  createdAt             updatedAt             createdBy                             updatedBy\n" +
+		"00000000-0000-0000-0000-000000000000  name0  00000000-0000-0000-0000-000000000001                                        1        2026-10-18T12:00:00Z  2026-10-18T12:00:00Z                                        \n" +
+		"00000000-0000-0000-0000-000000000002  name2  00000000-0000-0000-0000-000000000001  00000000-0000-0000-0000-000000000000  2        2026-10-18T12:00:00Z  2026-10-18T12:00:00Z  00000000-0000-0000-0000-000000000001  00000000-0000-0000-0000-000000000001\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, writeRecords(buf, formatJson, tenants[1]))
+	expected = `{
+  "id": "00000000-0000-0000-0000-000000000002",
+  "name": "name2",
+  "ownerId": "00000000-0000-0000-0000-000000000001",
+  "parentId": "00000000-0000-0000-0000-000000000000",
+  "version": 2,
+  "createdAt": "2026-10-18T12:00:00Z",
+  "updatedAt": "2026-10-18T12:00:00Z",
+  "createdBy": "00000000-0000-0000-0000-000000000001",
+  "updatedBy": "00000000-0000-0000-0000-000000000001"
+}
+`
+	assert.Equal(t, expected, buf.String())
+
+	assert.NotNil(t, writeRecords(buf, "xml", tenants))
+}
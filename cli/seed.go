@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/seed"
+	"github.com/urfave/cli"
+	"gopkg.in/testfixtures.v2"
+)
+
+// NewSeedCommand returns a command that seeds a development database with fixtures or generated data
+func NewSeedCommand(name string, chVars chan data.Vars) cli.Command {
+	var vars data.Vars
+	var force bool
+
+	return cli.Command{
+		Name:  name,
+		Usage: "seed a development database",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:        "force",
+				Usage:       "seed the database even if DB_ALLOW_SEED is not set",
+				Destination: &force,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			vars = <-chVars
+
+			return checkSeedAllowed(vars, force)
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "fixtures",
+				Usage: "load a fixture directory, replacing the rows of every table that has a fixture file",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "dir",
+						Usage: "the fixture `DIR`",
+						Value: "data/fixtures",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if vars.Isolation == data.IsolationSchema {
+						return errors.New("fixtures can only be loaded into a database with shared isolation")
+					}
+
+					d, err := data.OpenDb(vars)
+
+					if err != nil {
+						return err
+					}
+
+					defer d.Close()
+
+					// the database was checked before the subcommand ran
+					testfixtures.SkipDatabaseNameCheck(true)
+
+					fixtures, err := testfixtures.NewFolder(d, &testfixtures.PostgreSQL{}, c.String("dir"))
+
+					if err != nil {
+						return err
+					}
+
+					if err := fixtures.Load(); err != nil {
+						return err
+					}
+
+					fmt.Printf("loaded fixtures from %s\n", c.String("dir"))
+					return nil
+				},
+			},
+			{
+				Name:  "generate",
+				Usage: "generate synthetic tenants with members and invitations",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "tenants",
+						Usage: "the number of tenants to generate",
+						Value: 10,
+					},
+					cli.Float64Flag{
+						Name:  "members",
+						Usage: "the mean number of members per tenant",
+						Value: seed.DefaultOptions(0).MeanMembers,
+					},
+					cli.Float64Flag{
+						Name:  "invites",
+						Usage: "the mean number of open invitations per tenant",
+						Value: seed.DefaultOptions(0).MeanInvites,
+					},
+					cli.Int64Flag{
+						Name:  "seed",
+						Usage: "seed the generator to reproduce a previous run",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := openStore(vars)
+
+					if err != nil {
+						return err
+					}
+
+					opts := seed.DefaultOptions(c.Int("tenants"))
+					opts.MeanMembers = c.Float64("members")
+					opts.MeanInvites = c.Float64("invites")
+
+					if c.IsSet("seed") {
+						opts.Seed = c.Int64("seed")
+					}
+
+					summary, err := seed.Generate(seedStore{store}, opts)
+
+					if summary != nil {
+						fmt.Printf("generated %d tenants, %d users, %d members and %d invitations (seed %d)\n",
+							summary.Tenants, summary.Users, summary.Members, summary.Joinrequests, opts.Seed)
+					}
+
+					return err
+				},
+			},
+		},
+	}
+}
+
+// seedStore creates the members and joinrequests of generated tenants through a TenantStore,
+// so that they are written to the tenant's schema when schemas are isolated
+type seedStore struct {
+	*data.Store
+}
+
+func (s seedStore) CreateMember(m *data.Member) (*data.Member, error) {
+	return s.ForTenant(m.TenantId).CreateMember(m)
+}
+
+func (s seedStore) CreateJoinrequest(jr *data.Joinrequest) (*data.Joinrequest, error) {
+	return s.ForTenant(jr.TenantId).CreateJoinrequest(jr)
+}
+
+// checkSeedAllowed returns an error unless the database is marked as disposable with DB_ALLOW_SEED or the seed is forced
+func checkSeedAllowed(vars data.Vars, force bool) error {
+	if force || vars.AllowSeed {
+		return nil
+	}
+
+	return fmt.Errorf("refusing to seed database %s, which is not marked as disposable; set DB_ALLOW_SEED=true for it or use --force", vars.Name)
+}
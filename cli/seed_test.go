@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckSeedAllowed(t *testing.T) {
+	// the name of the database does not matter
+	err := checkSeedAllowed(data.Vars{Name: "xtenancy_dev"}, false)
+	assert.EqualError(t, err, "refusing to seed database xtenancy_dev, which is not marked as disposable; set DB_ALLOW_SEED=true for it or use --force")
+
+	assert.Nil(t, checkSeedAllowed(data.Vars{Name: "prod", AllowSeed: true}, false))
+	assert.Nil(t, checkSeedAllowed(data.Vars{Name: "prod"}, true))
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"github.com/brietsparks/xtenancy/auth"
+	"github.com/brietsparks/xtenancy/config"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/brietsparks/xtenancy/server"
+	"github.com/brietsparks/xtenancy/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// how long in-flight requests may take to finish once the server is asked to stop
+const shutdownTimeout = 10 * time.Second
+
+// NewServeCommand returns a command that runs the http server
+func NewServeCommand(name string, chVars chan data.Vars, chConfig chan *config.Config) cli.Command {
+	var vars data.Vars
+	var cfg *config.Config
+	var addr string
+
+	return cli.Command{
+		Name:  name,
+		Usage: "run the http server",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "addr",
+				Usage:       "listen on `ADDR`, overriding HTTP_ADDR",
+				Value:       ":8080",
+				Destination: &addr,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			vars = <-chVars
+			cfg = <-chConfig
+			return nil
+		},
+		Action: func(c *cli.Context) error {
+			if !c.IsSet("addr") && cfg.Get("HTTP_ADDR") != "" {
+				addr = cfg.Get("HTTP_ADDR")
+			}
+
+			tracingConfig, err := tracing.NewConfig(cfg.Get)
+
+			if err != nil {
+				return err
+			}
+
+			tp, shutdownTracing, err := tracing.NewProvider(context.Background(), tracingConfig, os.Stdout)
+
+			if err != nil {
+				return err
+			}
+
+			defer shutdownTracing(context.Background())
+
+			d, err := data.OpenDb(vars)
+
+			if err != nil {
+				return err
+			}
+
+			defer d.Close()
+
+			registry := prometheus.NewRegistry()
+			store, err := newStore(d, vars, registry, data.WithTracerProvider(tp))
+
+			if err != nil {
+				return err
+			}
+
+			opts := server.Options{
+				Registry:       registry,
+				DB:             d,
+				DBName:         vars.Name,
+				TracerProvider: tp,
+				Checks:         health.ReadinessChecks(d, vars),
+			}
+
+			if authConfig := auth.NewConfig(cfg.Get); authConfig.Configured() {
+				authenticator, err := auth.NewAuthenticator(authConfig, store)
+
+				if err != nil {
+					return err
+				}
+
+				opts.Authenticate = authenticator.Middleware
+			} else {
+				logrus.Warn("no token verification key is configured, the authenticated endpoints are not served")
+			}
+
+			srv, err := server.New(store, opts)
+
+			if err != nil {
+				return err
+			}
+
+			return listenAndServe(&http.Server{Addr: addr, Handler: srv.Handler()})
+		},
+	}
+}
+
+// listenAndServe runs an http server until it fails or the process is interrupted, then shuts it down gracefully
+func listenAndServe(hs *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		logrus.WithField("addr", hs.Addr).Info("listening")
+		errs <- hs.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := hs.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"database/sql"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// openStore opens a data store with the configured connection pool, isolation mode and query instrumentation.
+// Its connections assume the configured admin role, so that commands can read and write the rows of every tenant
+func openStore(vars data.Vars) (*data.Store, error) {
+	d, err := data.OpenAdminDb(vars)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newStore(d, vars, prometheus.DefaultRegisterer)
+}
+
+// newStore creates a data store on an open database, registering its query and operation metrics with reg.
+// The configured actor, if any, is recorded as the creator or updater of the rows the store writes
+func newStore(d *sql.DB, vars data.Vars, reg prometheus.Registerer, extra ...data.StoreOption) (*data.Store, error) {
+	receiver, err := data.NewQueryReceiver(data.ReceiverOptions{
+		SlowThreshold: vars.SlowQueryThreshold,
+		Registerer:    reg,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := data.NewStoreMetrics(reg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []data.StoreOption{
+		data.WithEventReceiver(receiver),
+		data.WithObserver(metrics),
+	}
+
+	if vars.Isolation == data.IsolationSchema {
+		opts = append(opts, data.WithSchemaIsolation(vars))
+	}
+
+	s, err := data.NewStore(d, vars.MaxOpenConns, append(opts, extra...)...)
+
+	if err != nil || vars.Actor == "" {
+		return s, err
+	}
+
+	return s.WithActor(vars.Actor), nil
+}
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/gocraft/dbr/v2"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+)
+
+// NewTenantCommand returns a tenant administration command tree that can be used by a urfave/cli instance
+func NewTenantCommand(name string, chVars chan data.Vars) cli.Command {
+	var store *data.Store
+	var format string
+
+	return cli.Command{
+		Name:  name,
+		Usage: "manage tenants",
+		Flags: []cli.Flag{formatFlag(&format)},
+		Before: func(c *cli.Context) error {
+			s, err := openStore(<-chVars)
+			store = s
+			return err
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "create",
+				Usage: "create a tenant",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "name"},
+					cli.StringFlag{Name: "owner", Usage: "the `USER_ID` of the owner"},
+					cli.StringFlag{Name: "parent", Usage: "the `TENANT_ID` of the parent tenant"},
+				},
+				Action: func(c *cli.Context) error {
+					t := &data.Tenant{
+						Name:    c.String("name"),
+						OwnerId: c.String("owner"),
+					}
+
+					var err error
+
+					if parent := c.String("parent"); parent != "" {
+						t, err = store.CreateChildTenant(parent, t)
+					} else {
+						t, err = store.CreateTenant(t)
+					}
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, t)
+				},
+			},
+			{
+				Name:      "update",
+				Usage:     "update the given fields of a tenant",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "name"},
+					cli.StringFlag{Name: "parent", Usage: "move the tenant under the tenant `TENANT_ID`, or to the top level if it is empty"},
+					ifVersionFlag("tenant"),
+				},
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					t := &data.Tenant{Name: c.String("name")}
+
+					if parent := c.String("parent"); parent != "" {
+						t.ParentId = dbr.NewNullString(parent)
+					}
+
+					fields := setFields(c, map[string]string{
+						"name":   "Name",
+						"parent": "ParentId",
+					})
+
+					if err := store.UpdateTenant(args[0], c.Int("if-version"), t, fields...); err != nil {
+						return err
+					}
+
+					t, err = store.GetTenant(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, t)
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list tenants",
+				Action: func(c *cli.Context) error {
+					t, err := store.GetTenants()
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, t)
+				},
+			},
+			{
+				Name:      "transfer",
+				Usage:     "transfer the ownership of a tenant to a user",
+				ArgsUsage: "<tenant id> <user id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "tenant id", "user id")
+
+					if err != nil {
+						return err
+					}
+
+					if err := store.TransferTenant(args[0], args[1]); err != nil {
+						return err
+					}
+
+					t, err := store.GetTenant(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, t)
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "export a tenant, its members, its joinrequests and the users they reference",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "out, o", Usage: "write the archive to `FILE` instead of stdout"},
+					cli.BoolFlag{Name: "ndjson", Usage: "write one record per line"},
+				},
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					a, err := store.ExportTenant(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					archiveFormat := data.ArchiveJson
+
+					if c.Bool("ndjson") {
+						archiveFormat = data.ArchiveNdjson
+					}
+
+					out := c.String("out")
+
+					if out == "" {
+						return data.WriteTenantArchive(os.Stdout, a, archiveFormat)
+					}
+
+					f, err := os.Create(out)
+
+					if err != nil {
+						return err
+					}
+
+					if err := data.WriteTenantArchive(f, a, archiveFormat); err != nil {
+						_ = f.Close()
+						return err
+					}
+
+					if err := f.Close(); err != nil {
+						return err
+					}
+
+					fmt.Printf("exported tenant %s to %s\n", args[0], out)
+					return nil
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "import a tenant archive, giving every record a new id",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "on-conflict",
+						Usage: "reuse existing users with the same email address or auth id, or fail",
+						Value: data.UserConflictFail,
+					},
+					cli.StringFlag{Name: "parent", Usage: "the `TENANT_ID` of the parent of the imported tenant"},
+				},
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "file")
+
+					if err != nil {
+						return err
+					}
+
+					onConflict := c.String("on-conflict")
+
+					if onConflict != data.UserConflictReuse && onConflict != data.UserConflictFail {
+						return fmt.Errorf("unknown conflict policy %s", onConflict)
+					}
+
+					var r io.Reader = os.Stdin
+
+					if args[0] != "-" {
+						f, err := os.Open(args[0])
+
+						if err != nil {
+							return err
+						}
+
+						defer f.Close()
+						r = f
+					}
+
+					a, err := data.ReadTenantArchive(r)
+
+					if err != nil {
+						return err
+					}
+
+					opts := data.ImportOptions{OnUserConflict: onConflict}
+
+					if parent := c.String("parent"); parent != "" {
+						opts.ParentId = dbr.NewNullString(parent)
+					}
+
+					result, err := store.ImportTenant(a, opts)
+
+					if err != nil {
+						return err
+					}
+
+					if len(result.ReusedUsers) > 0 {
+						fmt.Fprintf(os.Stderr, "reused %d existing users\n", len(result.ReusedUsers))
+					}
+
+					return printRecords(format, result.Tenant)
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a tenant that has no members or joinrequests, its child tenants become top-level tenants",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					if err := store.DeleteTenant(args[0]); err != nil {
+						return err
+					}
+
+					fmt.Printf("deleted tenant %s\n", args[0])
+					return nil
+				},
+			},
+		},
+	}
+}
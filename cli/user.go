@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/urfave/cli"
+)
+
+// NewUserCommand returns a user administration command tree that can be used by a urfave/cli instance
+func NewUserCommand(name string, chVars chan data.Vars) cli.Command {
+	var store *data.Store
+	var format string
+
+	userFlags := []cli.Flag{
+		cli.StringFlag{Name: "auth-id", Usage: "the id of the user's external identity"},
+		cli.StringFlag{Name: "email"},
+		cli.StringFlag{Name: "first-name"},
+		cli.StringFlag{Name: "last-name"},
+	}
+
+	return cli.Command{
+		Name:  name,
+		Usage: "manage users",
+		Flags: []cli.Flag{formatFlag(&format)},
+		Before: func(c *cli.Context) error {
+			s, err := openStore(<-chVars)
+			store = s
+			return err
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "create",
+				Usage: "create a user",
+				Flags: userFlags,
+				Action: func(c *cli.Context) error {
+					u, err := store.CreateUser(&data.User{
+						AuthId:    c.String("auth-id"),
+						Email:     c.String("email"),
+						FirstName: c.String("first-name"),
+						LastName:  c.String("last-name"),
+					})
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, u)
+				},
+			},
+			{
+				Name:      "get",
+				Usage:     "get a user by id",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					u, err := store.GetUser(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					if u == nil {
+						return data.NewError(data.ErrResourceDNE)
+					}
+
+					return printRecords(format, u)
+				},
+			},
+			{
+				Name:      "find-by-email",
+				Usage:     "get a user by email",
+				ArgsUsage: "<email>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "email")
+
+					if err != nil {
+						return err
+					}
+
+					u, err := store.GetUserByEmail(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					if u == nil {
+						return data.NewError(data.ErrResourceDNE)
+					}
+
+					return printRecords(format, u)
+				},
+			},
+			{
+				Name:      "update",
+				Usage:     "update the given fields of a user",
+				ArgsUsage: "<id>",
+				Flags:     append(userFlags, ifVersionFlag("user")),
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					u := &data.User{
+						AuthId:    c.String("auth-id"),
+						Email:     c.String("email"),
+						FirstName: c.String("first-name"),
+						LastName:  c.String("last-name"),
+					}
+
+					fields := setFields(c, map[string]string{
+						"auth-id":    "AuthId",
+						"email":      "Email",
+						"first-name": "FirstName",
+						"last-name":  "LastName",
+					})
+
+					if err := store.UpdateUser(args[0], c.Int("if-version"), u, fields...); err != nil {
+						return err
+					}
+
+					u, err = store.GetUser(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(format, u)
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a user",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					if err := store.DeleteUser(args[0]); err != nil {
+						return err
+					}
+
+					fmt.Printf("deleted user %s\n", args[0])
+					return nil
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "export everything stored about a user as json",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					export, err := store.ExportUserData(args[0])
+
+					if err != nil {
+						return err
+					}
+
+					return printRecords(formatJson, export)
+				},
+			},
+			{
+				Name:      "erase",
+				Usage:     "anonymize the personal data of a user, keeping their memberships and ownerships",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "yes, y", Usage: "erase without asking for confirmation"},
+				},
+				Action: func(c *cli.Context) error {
+					args, err := requireArgs(c, "id")
+
+					if err != nil {
+						return err
+					}
+
+					if !c.Bool("yes") && !confirm(fmt.Sprintf("Erase the personal data of user %s? This cannot be undone", args[0])) {
+						return errors.New("aborted")
+					}
+
+					if err := store.EraseUser(args[0]); err != nil {
+						return err
+					}
+
+					fmt.Printf("erased user %s\n", args[0])
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configuration sources, from lowest to highest precedence
+const SourceDefault = "default"
+const SourceFile = "file"
+const SourceEnvFile = "env file"
+const SourceEnv = "env"
+const SourceFlag = "flag"
+
+// SourceSecretFile is the source of a secret read from the file named by its _FILE key, e.g. DB_PASSWORD_FILE
+const SourceSecretFile = "secret file"
+
+// suffix of the keys that name a file holding the value of a secret key
+const fileSuffix = "_FILE"
+
+// secret files that are read by the package that uses the secret, so that it can read a rotated secret again.
+// Their secret key is left unset
+var consumerSecretFiles = map[string]bool{
+	"DB_PASSWORD_FILE": true,
+}
+
+// DefaultEnvFile is the .env file that is loaded when it exists and no other .env file is given
+const DefaultEnvFile = ".env"
+
+// prefixes of the environment variables that are configuration
+var envPrefixes = []string{"DB_", "AUTH_", "MIGRATIONS_", "LOG_", "HTTP_", "TRACING_"}
+
+// Options names the sources of a configuration
+type Options struct {
+	// Defaults are the values used when no other source sets a key
+	Defaults map[string]string
+	// File is a YAML or TOML config file, chosen by its extension
+	File string
+	// EnvFile is a .env file. If it is empty, DefaultEnvFile is loaded when it exists
+	EnvFile string
+	// Overrides are values given as command line flags
+	Overrides map[string]string
+}
+
+// Config is a resolved configuration keyed by environment variable name, e.g. DB_HOST
+type Config struct {
+	values  map[string]string
+	sources map[string]string
+}
+
+// Load resolves a configuration from defaults, a config file, a .env file, the environment and flags,
+// each overriding the previous one. The resolved values are only available through Get,
+// they are not exported to the environment where child processes would inherit secrets
+func Load(opts Options) (*Config, error) {
+	c := &Config{
+		values:  map[string]string{},
+		sources: map[string]string{},
+	}
+
+	c.merge(opts.Defaults, SourceDefault)
+
+	if opts.File != "" {
+		values, err := readFile(opts.File)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.merge(values, SourceFile)
+	}
+
+	envFile := opts.EnvFile
+
+	if envFile == "" {
+		if _, err := os.Stat(DefaultEnvFile); err == nil {
+			envFile = DefaultEnvFile
+		}
+	}
+
+	if envFile != "" {
+		values, err := godotenv.Read(envFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env file: %w", err)
+		}
+
+		c.merge(values, SourceEnvFile)
+	}
+
+	c.merge(environ(c.values), SourceEnv)
+	c.merge(opts.Overrides, SourceFlag)
+
+	if err := c.readSecretFiles(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) merge(values map[string]string, source string) {
+	for k, v := range values {
+		k = normalizeKey(k)
+		c.values[k] = v
+		c.sources[k] = source
+	}
+}
+
+// readSecretFiles sets every secret key whose _FILE key is set to the contents of the file it names,
+// except for the files that the consumer of the secret reads itself
+func (c *Config) readSecretFiles() error {
+	for _, k := range c.Keys() {
+		if !strings.HasSuffix(k, fileSuffix) || c.values[k] == "" {
+			continue
+		}
+
+		key := strings.TrimSuffix(k, fileSuffix)
+
+		if !isSecret(key) {
+			continue
+		}
+
+		if c.values[key] != "" && c.sources[key] != SourceDefault {
+			return fmt.Errorf("%s and %s are both set", key, k)
+		}
+
+		if consumerSecretFiles[k] {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(c.values[k])
+
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", k, err)
+		}
+
+		c.values[key] = strings.TrimRight(string(b), "\r\n")
+		c.sources[key] = SourceSecretFile
+	}
+
+	return nil
+}
+
+// Get returns the value of a key, or an empty string if it is not set
+func (c *Config) Get(key string) string {
+	return c.values[key]
+}
+
+// Source returns the source that set a key
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Keys returns the keys that are set, in alphabetical order
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.values))
+
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Redacted returns the value of a key with secrets replaced, for printing
+func (c *Config) Redacted(key string) string {
+	return Redact(key, c.values[key])
+}
+
+// Redact replaces the value of a secret key, and the password of a connection url
+func Redact(key string, value string) string {
+	if value == "" {
+		return value
+	}
+
+	if isSecret(key) {
+		return redacted
+	}
+
+	if strings.HasSuffix(key, "_URL") {
+		return redactUrl(value)
+	}
+
+	return value
+}
+
+const redacted = "[redacted]"
+
+// isSecret reports whether a key holds a secret. Keys that name a secret file are not secrets themselves
+func isSecret(key string) bool {
+	if strings.HasSuffix(key, fileSuffix) {
+		return false
+	}
+
+	return strings.Contains(key, "PASSWORD") || strings.Contains(key, "SECRET") || strings.Contains(key, "TOKEN")
+}
+
+func redactUrl(value string) string {
+	scheme := strings.Index(value, "://")
+	at := strings.LastIndex(value, "@")
+
+	if scheme < 0 || at < scheme {
+		return value
+	}
+
+	userinfo := value[scheme+3 : at]
+	colon := strings.Index(userinfo, ":")
+
+	if colon < 0 {
+		return value
+	}
+
+	return value[:scheme+3] + userinfo[:colon+1] + redacted + value[at:]
+}
+
+// environ returns the environment variables that are configuration,
+// that is the variables with a known prefix and those that override a key that is already set
+func environ(known map[string]string) map[string]string {
+	values := map[string]string{}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		if _, ok := known[parts[0]]; ok || hasEnvPrefix(parts[0]) {
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	return values
+}
+
+func hasEnvPrefix(key string) bool {
+	for _, prefix := range envPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readFile reads a YAML or TOML config file. Nested tables are flattened into keys joined by underscores,
+// so that "db: {host: x}" and "DB_HOST: x" both set DB_HOST
+func readFile(filename string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var y map[interface{}]interface{}
+
+		if err := yaml.Unmarshal(b, &y); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+
+		doc = stringKeys(y)
+	case ".toml":
+		if err := toml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("config file %s is neither YAML nor TOML", filename)
+	}
+
+	values := map[string]string{}
+
+	if err := flatten(values, "", doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, doc map[string]interface{}) error {
+	for k, v := range doc {
+		key := normalizeKey(k)
+
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := flatten(values, key, v); err != nil {
+				return err
+			}
+		case map[interface{}]interface{}:
+			if err := flatten(values, key, stringKeys(v)); err != nil {
+				return err
+			}
+		case []interface{}, []map[string]interface{}:
+			return fmt.Errorf("%s is a list, only scalar values are supported", key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	doc := make(map[string]interface{}, len(m))
+
+	for k, v := range m {
+		doc[fmt.Sprint(k)] = v
+	}
+
+	return doc
+}
+
+func normalizeKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
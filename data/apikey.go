@@ -0,0 +1,346 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// api keys are presented as "<apiKeyScheme><prefix>.<secret>".
+// The prefix is stored in plain text to look up the key, only a hash of the secret is stored
+const apiKeyScheme = "xtk_"
+const apiKeyPrefixBytes = 8
+const apiKeySecretBytes = 32
+
+// CreateServiceAccount creates a new service account within a tenant
+func (s *Store) CreateServiceAccount(sa *ServiceAccount) (_ *ServiceAccount, err error) {
+	s, end := s.observe("CreateServiceAccount")
+	defer end(&err)
+
+	sa.Id = uuid.New().String()
+	sa.Version = 1
+	sa.CreatedAt = now()
+
+	if sa.Roles == nil {
+		sa.Roles = []string{}
+	}
+
+	if err := s.validate(sa); err != nil {
+		return nil, err
+	}
+
+	columns := []string{"id", "tenant_id", "name", "roles", "created_at", "version"}
+	err = s.create("service_account", sa, columns)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return sa, nil
+}
+
+// UpdateServiceAccount updates an existing service account.
+// The variadic "fields" arg should contain the field names that should be updated
+func (s *Store) UpdateServiceAccount(id string, version int, sa *ServiceAccount, fields ...string) (err error) {
+	s, end := s.observe("UpdateServiceAccount")
+	defer end(&err)
+
+	if err := s.validatePartial(sa, fields...); err != nil {
+		return err
+	}
+
+	err = s.update("service_account", id, version, fields,
+		set{"Name", "name", sa.Name},
+		set{"Roles", "roles", sa.Roles},
+	)
+
+	return NewDbError(err)
+}
+
+// GetServiceAccount gets a service account by id
+func (s *Store) GetServiceAccount(id string) (_ *ServiceAccount, err error) {
+	s, end := s.observe("GetServiceAccount")
+	defer end(&err)
+
+	sa := &ServiceAccount{}
+	retrieved, count, err := s.getById("service_account", id, sa)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return retrieved.(*ServiceAccount), nil
+}
+
+// GetServiceAccountsByTenantId gets the service accounts of a tenant
+func (s *Store) GetServiceAccountsByTenantId(tenantId string) (_ []*ServiceAccount, err error) {
+	s, end := s.observe("GetServiceAccountsByTenantId")
+	defer end(&err)
+
+	var sa []*ServiceAccount
+
+	_, err = s.db.
+		Select("*").
+		From("service_account").
+		Where("tenant_id = ?", tenantId).
+		OrderBy("name").
+		LoadContext(s.context(), &sa)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return sa, nil
+}
+
+// DeleteServiceAccount deletes a service account along with its api keys
+func (s *Store) DeleteServiceAccount(id string) (err error) {
+	s, end := s.observe("DeleteServiceAccount")
+	defer end(&err)
+
+	err = s.delete("service_account", id)
+	return NewDbError(err)
+}
+
+// CreateApiKey creates a new api key for a service account.
+// The returned plain text key is not stored and cannot be retrieved again
+func (s *Store) CreateApiKey(serviceAccountId string) (_ *ApiKey, _ string, err error) {
+	s, end := s.observe("CreateApiKey")
+	defer end(&err)
+
+	prefix, secret, err := generateApiKey()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &ApiKey{
+		Id:               uuid.New().String(),
+		ServiceAccountId: serviceAccountId,
+		Prefix:           prefix,
+		SecretHash:       hashApiKeySecret(secret),
+		CreatedAt:        now(),
+		Version:          1,
+	}
+
+	if err := s.validate(k); err != nil {
+		return nil, "", err
+	}
+
+	columns := []string{"id", "service_account_id", "prefix", "secret_hash", "created_at", "version"}
+	err = s.create("api_key", k, columns)
+
+	if err != nil {
+		return nil, "", NewDbError(err)
+	}
+
+	return k, formatApiKey(prefix, secret), nil
+}
+
+// GetApiKey gets an api key by id
+func (s *Store) GetApiKey(id string) (_ *ApiKey, err error) {
+	s, end := s.observe("GetApiKey")
+	defer end(&err)
+
+	k := &ApiKey{}
+	retrieved, count, err := s.getById("api_key", id, k)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return retrieved.(*ApiKey), nil
+}
+
+// GetApiKeysByServiceAccountId gets the api keys of a service account, including revoked keys
+func (s *Store) GetApiKeysByServiceAccountId(serviceAccountId string) (_ []*ApiKey, err error) {
+	s, end := s.observe("GetApiKeysByServiceAccountId")
+	defer end(&err)
+
+	var k []*ApiKey
+
+	_, err = s.db.
+		Select("*").
+		From("api_key").
+		Where("service_account_id = ?", serviceAccountId).
+		OrderBy("created_at").
+		LoadContext(s.context(), &k)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return k, nil
+}
+
+// RevokeApiKey revokes an api key so that it can no longer be used to authenticate
+func (s *Store) RevokeApiKey(id string) (err error) {
+	s, end := s.observe("RevokeApiKey")
+	defer end(&err)
+
+	result, err := s.db.
+		Update("api_key").
+		Set("revoked_at", time.Now()).
+		SetMap(s.updateStamp()).
+		Where("id = ? and revoked_at is null", id).
+		ExecContext(s.context())
+
+	if err != nil {
+		return NewDbError(err)
+	}
+
+	count, err := result.RowsAffected()
+
+	if err != nil {
+		return NewDbError(err)
+	}
+
+	if count == 0 {
+		return NewError(ErrResourceDNE)
+	}
+
+	return nil
+}
+
+// RotateApiKey replaces an active api key with a new key for the same service account and revokes the old key.
+// The old key is revoked first, so of two concurrent rotations of a key only one succeeds
+func (s *Store) RotateApiKey(id string) (_ *ApiKey, _ string, err error) {
+	s, end := s.observe("RotateApiKey")
+	defer end(&err)
+
+	var k *ApiKey
+	var key string
+
+	err = s.transaction(func(s *Store) error {
+		if err := s.RevokeApiKey(id); err != nil {
+			return err
+		}
+
+		old, err := s.GetApiKey(id)
+
+		if err != nil {
+			return err
+		}
+
+		k, key, err = s.CreateApiKey(old.ServiceAccountId)
+		return err
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return k, key, nil
+}
+
+// AuthenticateApiKey resolves a presented api key to its tenant and role set and records its usage
+func (s *Store) AuthenticateApiKey(key string) (_ *ApiKeyIdentity, err error) {
+	s, end := s.observe("AuthenticateApiKey")
+	defer end(&err)
+
+	prefix, secret, ok := parseApiKey(key)
+
+	if !ok {
+		return nil, NewError(ErrInvalidApiKey)
+	}
+
+	var row struct {
+		Id               string         `db:"id"`
+		ServiceAccountId string         `db:"service_account_id"`
+		SecretHash       []byte         `db:"secret_hash"`
+		TenantId         string         `db:"tenant_id"`
+		Roles            pq.StringArray `db:"roles"`
+	}
+
+	// the tenant of the key is not known yet, so its service account is looked up
+	// by a function that row level security does not restrict
+	stmt := s.db.SelectBySql("select * from api_key_by_prefix(?)", prefix)
+
+	count, err := stmt.LoadContext(s.context(), &row)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if count == 0 || subtle.ConstantTimeCompare(row.SecretHash, hashApiKeySecret(secret)) != 1 {
+		return nil, NewError(ErrInvalidApiKey)
+	}
+
+	err = s.ForTenant(row.TenantId).transaction(func(s *Store) error {
+		_, err := s.db.
+			Update("api_key").
+			Set("last_used_at", time.Now()).
+			Where("id = ?", row.Id).
+			ExecContext(s.context())
+
+		if err != nil {
+			return NewDbError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiKeyIdentity{
+		ApiKeyId:         row.Id,
+		ServiceAccountId: row.ServiceAccountId,
+		TenantId:         row.TenantId,
+		Roles:            row.Roles,
+	}, nil
+}
+
+func generateApiKey() (string, string, error) {
+	p := make([]byte, apiKeyPrefixBytes)
+	sec := make([]byte, apiKeySecretBytes)
+
+	if _, err := rand.Read(p); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	if _, err := rand.Read(sec); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	return hex.EncodeToString(p), base64.RawURLEncoding.EncodeToString(sec), nil
+}
+
+func formatApiKey(prefix string, secret string) string {
+	return apiKeyScheme + prefix + "." + secret
+}
+
+func parseApiKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, apiKeyScheme) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyScheme), ".", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+func hashApiKeySecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
@@ -0,0 +1,419 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gocraft/dbr/v2"
+	"github.com/google/uuid"
+	"io"
+	"strconv"
+	"time"
+)
+
+// TenantArchiveVersion is the version of the tenant archive format written by ExportTenant
+const TenantArchiveVersion = 1
+
+// tenant archive formats
+const ArchiveJson = "json"
+const ArchiveNdjson = "ndjson"
+
+// policies for imported users whose email address or auth id already belongs to a user
+const UserConflictReuse = "reuse"
+const UserConflictFail = "fail"
+
+// TenantArchive is a portable snapshot of a tenant, its members, its joinrequests and the users they reference
+type TenantArchive struct {
+	Version      int            `json:"version"`
+	ExportedAt   time.Time      `json:"exportedAt"`
+	Tenant       *Tenant        `json:"tenant"`
+	Users        []*User        `json:"users"`
+	Members      []*Member      `json:"members"`
+	Joinrequests []*Joinrequest `json:"joinrequests"`
+}
+
+// ImportOptions controls how a tenant archive is restored
+type ImportOptions struct {
+	// OnUserConflict is either UserConflictReuse or UserConflictFail
+	OnUserConflict string
+	// ParentId is the parent of the imported tenant, the parent recorded in the archive is not restored
+	ParentId dbr.NullString
+}
+
+// ImportResult describes a restored tenant archive
+type ImportResult struct {
+	Tenant *Tenant
+	// Ids maps the ids in the archive to the ids of the restored records
+	Ids map[string]string
+	// ReusedUsers are the ids of existing users that archived users were mapped to by email
+	ReusedUsers []string
+}
+
+// ExportTenant creates an archive of a tenant
+func (s *Store) ExportTenant(tenantId string) (_ *TenantArchive, err error) {
+	s, end := s.observe("ExportTenant")
+	defer end(&err)
+
+	var archive *TenantArchive
+
+	// the records are read in one snapshot, so that the archive is consistent while the tenant changes
+	err = s.snapshot(func(s *Store) error {
+		ts := s.ForTenant(tenantId)
+
+		t, err := ts.GetTenant()
+
+		if err != nil {
+			return err
+		}
+
+		if t == nil {
+			return NewError(ErrResourceDNE)
+		}
+
+		members, err := ts.GetMembers()
+
+		if err != nil {
+			return err
+		}
+
+		joinrequests, err := ts.GetJoinrequests()
+
+		if err != nil {
+			return err
+		}
+
+		userIds := []string{t.OwnerId}
+
+		for _, m := range members {
+			userIds = append(userIds, m.UserId)
+		}
+
+		for _, jr := range joinrequests {
+			if jr.UserId.Valid {
+				userIds = append(userIds, jr.UserId.String)
+			}
+		}
+
+		var users []*User
+		seen := map[string]bool{}
+
+		for _, id := range userIds {
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+
+			u, err := s.GetUser(id)
+
+			if err != nil {
+				return err
+			}
+
+			if u != nil {
+				users = append(users, u)
+			}
+		}
+
+		archive = &TenantArchive{
+			Version:      TenantArchiveVersion,
+			ExportedAt:   time.Now().UTC(),
+			Tenant:       t,
+			Users:        users,
+			Members:      members,
+			Joinrequests: joinrequests,
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// matchImportedUser returns the existing user with the email address of an archived user,
+// or else the one with its auth id, since both are unique
+func (s *Store) matchImportedUser(u *User) (*User, error) {
+	existing, err := s.GetUserByEmail(u.Email)
+
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	return s.GetUserByAuthId(u.AuthId)
+}
+
+// ImportTenant restores a tenant archive in a single transaction.
+// Every restored record gets a new id. Archived users are matched to existing users by email and by auth id,
+// and are either reused or rejected with ErrUserConflict depending on the options
+func (s *Store) ImportTenant(a *TenantArchive, opts ImportOptions) (_ *ImportResult, err error) {
+	s, end := s.observe("ImportTenant")
+	defer end(&err)
+
+	if a.Version != TenantArchiveVersion {
+		return nil, newErrorf(ErrArchiveVersion, strconv.Itoa(a.Version))
+	}
+
+	if a.Tenant == nil {
+		return nil, NewError(ErrArchiveIncomplete)
+	}
+
+	result := &ImportResult{Ids: map[string]string{}}
+
+	err = s.transaction(func(s *Store) error {
+		for _, u := range a.Users {
+			existing, err := s.matchImportedUser(u)
+
+			if err != nil {
+				return err
+			}
+
+			if existing != nil {
+				if opts.OnUserConflict != UserConflictReuse {
+					return newErrorf(ErrUserConflict, u.Email)
+				}
+
+				result.Ids[u.Id] = existing.Id
+				result.ReusedUsers = append(result.ReusedUsers, existing.Id)
+				continue
+			}
+
+			imported := *u
+			created, err := s.CreateUser(&imported)
+
+			if err != nil {
+				return err
+			}
+
+			result.Ids[u.Id] = created.Id
+		}
+
+		t := &Tenant{
+			Name:     a.Tenant.Name,
+			OwnerId:  result.Ids[a.Tenant.OwnerId],
+			ParentId: opts.ParentId,
+		}
+
+		if t.OwnerId == "" {
+			return NewError(ErrArchiveIncomplete)
+		}
+
+		t, err := s.CreateTenant(t)
+
+		if err != nil {
+			return err
+		}
+
+		result.Tenant = t
+		result.Ids[a.Tenant.Id] = t.Id
+
+		return s.ForTenant(t.Id).transaction(func(s *Store) error {
+			for _, m := range a.Members {
+				imported := *m
+				imported.Id = uuid.New().String()
+				imported.Version = 1
+				imported.TenantId = t.Id
+				imported.UserId = result.Ids[m.UserId]
+
+				if imported.UserId == "" {
+					return NewError(ErrArchiveIncomplete)
+				}
+
+				if err := s.validate(&imported); err != nil {
+					return err
+				}
+
+				columns := []string{"id", "tenant_id", "user_id", "alias", "is_admin", "is_inactive", "version"}
+
+				if err := s.create("member", &imported, columns); err != nil {
+					return NewDbError(err)
+				}
+
+				result.Ids[m.Id] = imported.Id
+			}
+
+			for _, jr := range a.Joinrequests {
+				imported := *jr
+				imported.Id = uuid.New().String()
+				imported.Version = 1
+				imported.TenantId = t.Id
+
+				if jr.UserId.Valid {
+					userId, ok := result.Ids[jr.UserId.String]
+
+					if !ok {
+						return NewError(ErrArchiveIncomplete)
+					}
+
+					imported.UserId = dbr.NewNullString(userId)
+				}
+
+				if err := s.validate(&imported); err != nil {
+					return err
+				}
+
+				columns := []string{"id", "tenant_id", "user_id", "anon_email", "is_accepted", "is_from_user", "created_at", "expires_at", "version"}
+
+				if err := s.create("joinrequest", &imported, columns); err != nil {
+					return NewDbError(err)
+				}
+
+				result.Ids[jr.Id] = imported.Id
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// archiveLine is a line of an ndjson tenant archive.
+// The first line is a header that carries the version, every following line carries one record
+type archiveLine struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version,omitempty"`
+	ExportedAt *time.Time      `json:"exportedAt,omitempty"`
+	Record     json.RawMessage `json:"record,omitempty"`
+}
+
+// archive line types
+const (
+	archiveHeader      = "header"
+	archiveTenant      = "tenant"
+	archiveUser        = "user"
+	archiveMember      = "member"
+	archiveJoinrequest = "joinrequest"
+)
+
+// WriteTenantArchive writes an archive as a single json document or as ndjson with one record per line
+func WriteTenantArchive(w io.Writer, a *TenantArchive, format string) error {
+	switch format {
+	case ArchiveJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a)
+	case ArchiveNdjson:
+		enc := json.NewEncoder(w)
+
+		if err := enc.Encode(archiveLine{Type: archiveHeader, Version: a.Version, ExportedAt: &a.ExportedAt}); err != nil {
+			return err
+		}
+
+		write := func(t string, record interface{}) error {
+			b, err := json.Marshal(record)
+
+			if err != nil {
+				return err
+			}
+
+			return enc.Encode(archiveLine{Type: t, Record: b})
+		}
+
+		if err := write(archiveTenant, a.Tenant); err != nil {
+			return err
+		}
+
+		for _, u := range a.Users {
+			if err := write(archiveUser, u); err != nil {
+				return err
+			}
+		}
+
+		for _, m := range a.Members {
+			if err := write(archiveMember, m); err != nil {
+				return err
+			}
+		}
+
+		for _, jr := range a.Joinrequests {
+			if err := write(archiveJoinrequest, jr); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown archive format %s", format)
+	}
+}
+
+// ReadTenantArchive reads an archive written by WriteTenantArchive in either format
+func ReadTenantArchive(r io.Reader) (*TenantArchive, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var first json.RawMessage
+
+	if err := dec.Decode(&first); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	var header archiveLine
+
+	if err := json.Unmarshal(first, &header); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if header.Type != archiveHeader {
+		a := &TenantArchive{}
+
+		if err := json.Unmarshal(first, a); err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		return a, nil
+	}
+
+	a := &TenantArchive{Version: header.Version}
+
+	if header.ExportedAt != nil {
+		a.ExportedAt = *header.ExportedAt
+	}
+
+	for {
+		var line archiveLine
+
+		err := dec.Decode(&line)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		switch line.Type {
+		case archiveTenant:
+			a.Tenant = &Tenant{}
+			err = json.Unmarshal(line.Record, a.Tenant)
+		case archiveUser:
+			u := &User{}
+			err = json.Unmarshal(line.Record, u)
+			a.Users = append(a.Users, u)
+		case archiveMember:
+			m := &Member{}
+			err = json.Unmarshal(line.Record, m)
+			a.Members = append(a.Members, m)
+		case archiveJoinrequest:
+			jr := &Joinrequest{}
+			err = json.Unmarshal(line.Record, jr)
+			a.Joinrequests = append(a.Joinrequests, jr)
+		default:
+			err = fmt.Errorf("unknown archive record type %s", line.Type)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+	}
+
+	return a, nil
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"bytes"
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTenantArchiveRoundTrip(t *testing.T) {
+	a := &TenantArchive{
+		Version:    TenantArchiveVersion,
+		ExportedAt: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Tenant:     &Tenant{Id: "00000000-0000-0000-0000-000000000000", Name: "name0", OwnerId: "00000000-0000-0000-0000-000000000001"},
+		Users: []*User{
+			{Id: "00000000-0000-0000-0000-000000000001", AuthId: "00000000-0000-0000-0000-000000000001", Email: "a@a.a", FirstName: "a", LastName: "a"},
+		},
+		Members: []*Member{
+			{Id: "00000000-0000-0000-0000-000000000002", TenantId: "00000000-0000-0000-0000-000000000000", UserId: "00000000-0000-0000-0000-000000000001", IsAdmin: true},
+		},
+		Joinrequests: []*Joinrequest{
+			{
+				Id:        "00000000-0000-0000-0000-000000000003",
+				TenantId:  "00000000-0000-0000-0000-000000000000",
+				AnonEmail: dbr.NewNullString("b@b.b"),
+				CreatedAt: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, format := range []string{ArchiveJson, ArchiveNdjson} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, WriteTenantArchive(buf, a, format))
+
+		read, err := ReadTenantArchive(buf)
+		assert.Nil(t, err, format)
+		assert.Equal(t, a, read, format)
+	}
+
+	_, err := ReadTenantArchive(bytes.NewBufferString(`{"type":"header","version":1}` + "\n" + `{"type":"group","record":{}}`))
+	assert.NotNil(t, err)
+}
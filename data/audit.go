@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"github.com/gocraft/dbr/v2"
+	"reflect"
+	"time"
+)
+
+// audit columns that the create and update helpers maintain
+const (
+	colCreatedAt = "created_at"
+	colUpdatedAt = "updated_at"
+	colCreatedBy = "created_by"
+	colUpdatedBy = "updated_by"
+)
+
+// WithActor returns a copy of the store that records a user as the creator or updater of the rows it writes
+func (s *Store) WithActor(userId string) *Store {
+	c := *s
+	c.actor = dbr.NewNullString(userId)
+	return &c
+}
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx that carries the user acting in it, e.g. the authenticated user of a request.
+// A store bound to the context with WithContext records the user as the creator or updater of the rows it writes
+func ContextWithActor(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, actorKey{}, userId)
+}
+
+// ActorFromContext returns the user acting in ctx, if any
+func ActorFromContext(ctx context.Context) (string, bool) {
+	userId, ok := ctx.Value(actorKey{}).(string)
+	return userId, ok && userId != ""
+}
+
+// now returns the current time in UTC, at the precision the database stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// stampCreated sets the audit fields of a record that is about to be created and adds their columns.
+// A creation time or creator that is already set, e.g. by an import, is kept
+func (s *Store) stampCreated(record interface{}, columns []string) []string {
+	v := reflect.Indirect(reflect.ValueOf(record))
+
+	createdAt := v.FieldByName("CreatedAt")
+
+	if !createdAt.IsValid() {
+		return columns
+	}
+
+	if createdAt.Interface().(time.Time).IsZero() {
+		createdAt.Set(reflect.ValueOf(now()))
+	}
+
+	createdBy := v.FieldByName("CreatedBy")
+
+	if !createdBy.Interface().(dbr.NullString).Valid {
+		createdBy.Set(reflect.ValueOf(s.actor))
+	}
+
+	v.FieldByName("UpdatedAt").Set(createdAt)
+	v.FieldByName("UpdatedBy").Set(createdBy)
+
+	for _, col := range []string{colCreatedAt, colUpdatedAt, colCreatedBy, colUpdatedBy} {
+		if !includes(columns, col) {
+			columns = append(columns, col)
+		}
+	}
+
+	return columns
+}
+
+// updateStamp returns the audit and version columns of an update.
+// An update by an unknown user clears the updater, so that it is never attributed to a previous one
+func (s *Store) updateStamp() map[string]interface{} {
+	return map[string]interface{}{
+		colUpdatedAt: now(),
+		colUpdatedBy: s.actor,
+		"version":    incrementVersion,
+	}
+}
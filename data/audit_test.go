@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStampCreated(t *testing.T) {
+	s := (&Store{}).WithActor("00000000-0000-0000-0000-000000000001")
+
+	m := &Member{}
+	columns := s.stampCreated(m, []string{"id", "created_at"})
+
+	assert.Equal(t, []string{"id", "created_at", "updated_at", "created_by", "updated_by"}, columns)
+	assert.False(t, m.CreatedAt.IsZero())
+	assert.Equal(t, m.CreatedAt, m.UpdatedAt)
+	assert.Equal(t, dbr.NewNullString("00000000-0000-0000-0000-000000000001"), m.CreatedBy)
+	assert.Equal(t, m.CreatedBy, m.UpdatedBy)
+
+	// an imported record keeps its history
+	createdAt := time.Date(2019, 11, 19, 12, 0, 0, 0, time.UTC)
+	m = &Member{CreatedAt: createdAt, CreatedBy: dbr.NewNullString("00000000-0000-0000-0000-000000000002")}
+	s.stampCreated(m, nil)
+
+	assert.Equal(t, createdAt, m.CreatedAt)
+	assert.Equal(t, createdAt, m.UpdatedAt)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000002", m.CreatedBy.String)
+
+	// records without audit fields are left alone
+	assert.Equal(t, []string{"id"}, s.stampCreated(&EffectiveMember{}, []string{"id"}))
+}
+
+func TestUpdateStamp(t *testing.T) {
+	// an update by an unknown user sets the updater to NULL
+	stamp := (&Store{}).updateStamp()
+	assert.Equal(t, dbr.NullString{}, stamp[colUpdatedBy])
+	assert.Contains(t, stamp, colUpdatedAt)
+
+	value, err := stamp[colUpdatedBy].(dbr.NullString).Value()
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	stamp = (&Store{}).WithActor("00000000-0000-0000-0000-000000000001").updateStamp()
+	assert.Equal(t, dbr.NewNullString("00000000-0000-0000-0000-000000000001"), stamp[colUpdatedBy])
+}
+
+func TestContextActor(t *testing.T) {
+	_, ok := ActorFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := ContextWithActor(context.Background(), "00000000-0000-0000-0000-000000000001")
+	s := (&Store{}).WithContext(ctx)
+	assert.Equal(t, dbr.NewNullString("00000000-0000-0000-0000-000000000001"), s.actor)
+
+	// a context without an actor keeps the store's actor
+	s = s.WithContext(context.Background())
+	assert.Equal(t, dbr.NewNullString("00000000-0000-0000-0000-000000000001"), s.actor)
+}
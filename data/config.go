@@ -1,42 +1,213 @@
 package data
 
 import (
+	"database/sql"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Vars struct {
+	// Url is a postgres connection url. When it is set, it supersedes the individual connection settings
+	Url string
 	User string
 	Password string
+	// PasswordFile is a file holding the password, e.g. a mounted secret. It is read again when the password is rejected
+	PasswordFile string
 	Host string
 	Port string
 	Name string
+	// SslMode is a libpq sslmode, e.g. disable, require or verify-full
+	SslMode string
+	// SslRootCert, SslCert and SslKey are the paths of the CA certificate, client certificate and client key
+	SslRootCert string
+	SslCert string
+	SslKey string
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime size the connection pool
+	MaxOpenConns int
+	MaxIdleConns int
+	ConnMaxLifetime time.Duration
+	// SlowQueryThreshold is the duration from which a query is logged as slow. Zero disables slow query logs
+	SlowQueryThreshold time.Duration
+	// Isolation is the tenant isolation mode, either IsolationShared or IsolationSchema
+	Isolation string
+	// MigrationsDir overrides the migrations embedded in the binary with a directory on disk
+	MigrationsDir string
+	// AllowSeed marks the database as disposable, so that the seed command may overwrite its data
+	AllowSeed bool
+	// Actor is the id of the user recorded as the creator or updater of the rows written by the store, if any
+	Actor string
+	// AdminRole is the role that the admin commands assume with SET ROLE, see OpenAdminDb.
+	// The role that connects must be a member of it
+	AdminRole string
 }
 
-// LoadEnvVars reads environment variables from a file and returns them as a Vars struct
+// DefaultEnv holds the default values of the database environment variables
+var DefaultEnv = map[string]string{
+	"DB_HOST":                 "localhost",
+	"DB_PORT":                 "5432",
+	"DB_SSLMODE":              "disable",
+	"DB_MAX_OPEN_CONNS":       "10",
+	"DB_MAX_IDLE_CONNS":       "2",
+	"DB_CONN_MAX_LIFETIME":    "30m",
+	"DB_SLOW_QUERY_THRESHOLD": "200ms",
+	"DB_ISOLATION":            IsolationShared,
+	"DB_ALLOW_SEED":           "false",
+}
+
+// LoadEnvVars reads environment variables from a file, if it exists, and returns them as a Vars struct.
+// Variables that are already set in the environment take precedence over the file
 func LoadEnvVars(filename string) (Vars, error) {
 	err := godotenv.Load(filename)
 
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return Vars{}, fmt.Errorf("failed to load env vars: %w", err)
 	}
 
-	return Vars{
-		User: os.Getenv("DB_USER"),
-		Password: os.Getenv("DB_PASSWORD"),
-		Host: os.Getenv("DB_HOST"),
-		Port: os.Getenv("DB_PORT"),
-		Name: os.Getenv("DB_NAME"),
-	}, nil
+	return NewVars(os.Getenv)
+}
+
+// NewVars reads the database environment variables through getenv, falling back to DefaultEnv
+func NewVars(getenv func(key string) string) (Vars, error) {
+	get := func(key string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+
+		return DefaultEnv[key]
+	}
+
+	vars := Vars{
+		Url: get("DB_URL"),
+		User: get("DB_USER"),
+		Password: get("DB_PASSWORD"),
+		PasswordFile: get("DB_PASSWORD_FILE"),
+		Host: get("DB_HOST"),
+		Port: get("DB_PORT"),
+		Name: get("DB_NAME"),
+		SslMode: get("DB_SSLMODE"),
+		SslRootCert: get("DB_SSLROOTCERT"),
+		SslCert: get("DB_SSLCERT"),
+		SslKey: get("DB_SSLKEY"),
+		Isolation: get("DB_ISOLATION"),
+		MigrationsDir: get("MIGRATIONS_DIR"),
+		Actor: get("DB_ACTOR"),
+		AdminRole: get("DB_ADMIN_ROLE"),
+	}
+
+	var err error
+
+	if vars.MaxOpenConns, err = strconv.Atoi(get("DB_MAX_OPEN_CONNS")); err != nil {
+		return Vars{}, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: %w", err)
+	}
+
+	if vars.MaxIdleConns, err = strconv.Atoi(get("DB_MAX_IDLE_CONNS")); err != nil {
+		return Vars{}, fmt.Errorf("invalid DB_MAX_IDLE_CONNS: %w", err)
+	}
+
+	if vars.ConnMaxLifetime, err = time.ParseDuration(get("DB_CONN_MAX_LIFETIME")); err != nil {
+		return Vars{}, fmt.Errorf("invalid DB_CONN_MAX_LIFETIME: %w", err)
+	}
+
+	if vars.SlowQueryThreshold, err = time.ParseDuration(get("DB_SLOW_QUERY_THRESHOLD")); err != nil {
+		return Vars{}, fmt.Errorf("invalid DB_SLOW_QUERY_THRESHOLD: %w", err)
+	}
+
+	if vars.AllowSeed, err = strconv.ParseBool(get("DB_ALLOW_SEED")); err != nil {
+		return Vars{}, fmt.Errorf("invalid DB_ALLOW_SEED: %w", err)
+	}
+
+	if vars.Actor != "" {
+		if _, err := uuid.Parse(vars.Actor); err != nil {
+			return Vars{}, fmt.Errorf("invalid DB_ACTOR, expected a user id: %w", err)
+		}
+	}
+
+	if vars.Url != "" {
+		if err := vars.applyUrl(); err != nil {
+			return Vars{}, err
+		}
+	}
+
+	return vars, nil
+}
+
+// applyUrl sets the connection settings from Url.
+// The ssl settings that Url does not set keep their configured values, e.g. the default sslmode
+func (vars *Vars) applyUrl() error {
+	u, err := url.Parse(vars.Url)
+
+	if err != nil {
+		return fmt.Errorf("invalid DB_URL: %w", err)
+	}
+
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return fmt.Errorf("invalid DB_URL: unsupported scheme %q", u.Scheme)
+	}
+
+	vars.Host = u.Hostname()
+	vars.Port = u.Port()
+	vars.Name = strings.TrimPrefix(u.Path, "/")
+	vars.User = u.User.Username()
+	vars.Password, _ = u.User.Password()
+
+	q := u.Query()
+
+	for key, v := range map[string]*string{
+		"sslmode":     &vars.SslMode,
+		"sslrootcert": &vars.SslRootCert,
+		"sslcert":     &vars.SslCert,
+		"sslkey":      &vars.SslKey,
+	} {
+		if _, ok := q[key]; ok {
+			*v = q.Get(key)
+		}
+	}
+
+	return nil
 }
 
-// MakeUrl returns a postgres url from a Vars struct
+// MakeUrl returns a postgres connection string from a Vars struct
 func MakeUrl(vars Vars) string {
-	return fmt.Sprintf("dbname=%s user=%s password=%s host=%s sslmode=disable",
-		vars.Name,
-		vars.User,
-		vars.Password,
-		vars.Host,
-	)
+	params := []struct{ key, value string }{
+		{"dbname", vars.Name},
+		{"user", vars.User},
+		{"password", vars.Password},
+		{"host", vars.Host},
+		{"port", vars.Port},
+		{"sslmode", vars.SslMode},
+		{"sslrootcert", vars.SslRootCert},
+		{"sslcert", vars.SslCert},
+		{"sslkey", vars.SslKey},
+	}
+
+	var parts []string
+
+	for _, p := range params {
+		if p.value != "" {
+			parts = append(parts, p.key+"="+quoteParam(p.value))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// quoteParam quotes a connection string value that is empty or contains spaces, quotes or backslashes
+func quoteParam(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// OpenDb opens a database with the connection pool sized by the vars.
+// Connections get their credentials from the provider returned by NewCredentialProvider
+func OpenDb(vars Vars) (*sql.DB, error) {
+	return OpenDbWithCredentials(vars, NewCredentialProvider(vars))
 }
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewVars(t *testing.T) {
+	env := map[string]string{
+		"DB_NAME":     "xtenancy",
+		"DB_USER":     "postgres",
+		"DB_PASSWORD": "it's secret",
+	}
+
+	vars, err := NewVars(func(key string) string { return env[key] })
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", vars.Host)
+	assert.Equal(t, 10, vars.MaxOpenConns)
+	assert.Equal(t, 30*time.Minute, vars.ConnMaxLifetime)
+	assert.Equal(t, 200*time.Millisecond, vars.SlowQueryThreshold)
+	assert.False(t, vars.AllowSeed)
+	assert.Equal(t, `dbname=xtenancy user=postgres password='it\'s secret' host=localhost port=5432 sslmode=disable`, MakeUrl(vars))
+
+	env["DB_URL"] = "postgres://me:pw@db.example.com:6543/app?sslmode=verify-full&sslrootcert=/etc/ca.pem"
+	vars, err = NewVars(func(key string) string { return env[key] })
+	assert.Nil(t, err)
+	assert.Equal(t, "app", vars.Name)
+	assert.Equal(t, `dbname=app user=me password=pw host=db.example.com port=6543 sslmode=verify-full sslrootcert=/etc/ca.pem`, MakeUrl(vars))
+
+	// the url keeps the default sslmode when it does not set one
+	env["DB_URL"] = "postgres://me:pw@db.example.com:6543/app"
+	vars, err = NewVars(func(key string) string { return env[key] })
+	assert.Nil(t, err)
+	assert.Equal(t, `dbname=app user=me password=pw host=db.example.com port=6543 sslmode=disable`, MakeUrl(vars))
+
+	env["DB_SSLMODE"] = "require"
+	vars, err = NewVars(func(key string) string { return env[key] })
+	assert.Nil(t, err)
+	assert.Equal(t, "require", vars.SslMode)
+
+	env["DB_ACTOR"] = "00000000-0000-0000-0000-000000000001"
+	vars, err = NewVars(func(key string) string { return env[key] })
+	assert.Nil(t, err)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000001", vars.Actor)
+
+	env["DB_ACTOR"] = "admin"
+	_, err = NewVars(func(key string) string { return env[key] })
+	assert.NotNil(t, err)
+	delete(env, "DB_ACTOR")
+
+	env["DB_MAX_OPEN_CONNS"] = "many"
+	_, err = NewVars(func(key string) string { return env[key] })
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// postgres error codes of failed authentication
+const pqInvalidPassword = "28P01"
+const pqInvalidAuthorization = "28000"
+
+// CredentialProvider supplies the user and password of new database connections.
+// Credentials is called with refresh set when the database rejected the previous credentials,
+// so that a provider can fetch rotated credentials instead of returning cached ones
+type CredentialProvider interface {
+	Credentials(ctx context.Context, refresh bool) (user string, password string, err error)
+}
+
+// StaticCredentials is a CredentialProvider of fixed credentials
+type StaticCredentials struct {
+	User     string
+	Password string
+}
+
+func (c StaticCredentials) Credentials(ctx context.Context, refresh bool) (string, string, error) {
+	return c.User, c.Password, nil
+}
+
+// FileCredentials is a CredentialProvider that reads the password from a file, such as a mounted secret.
+// The file is read once and read again when the credentials are refreshed
+type FileCredentials struct {
+	User         string
+	PasswordFile string
+
+	mu       sync.Mutex
+	password string
+	loaded   bool
+}
+
+func (c *FileCredentials) Credentials(ctx context.Context, refresh bool) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded || refresh {
+		password, err := ReadSecretFile(c.PasswordFile)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		c.password = password
+		c.loaded = true
+	}
+
+	return c.User, c.password, nil
+}
+
+// ReadSecretFile reads a secret from a file, without the trailing newline that editors and secret mounts tend to add
+func ReadSecretFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// NewCredentialProvider returns the provider of the credentials in the vars,
+// reading the password from PasswordFile when it is set
+func NewCredentialProvider(vars Vars) CredentialProvider {
+	if vars.PasswordFile != "" {
+		return &FileCredentials{User: vars.User, PasswordFile: vars.PasswordFile}
+	}
+
+	return StaticCredentials{User: vars.User, Password: vars.Password}
+}
+
+// OpenDbWithCredentials opens a database whose connections get their credentials from a provider.
+// A connection that is rejected because of its credentials is retried once with refreshed credentials
+func OpenDbWithCredentials(vars Vars, provider CredentialProvider) (*sql.DB, error) {
+	return openDbWithCredentials(vars, provider, "", "")
+}
+
+// OpenAdminDb opens a database whose connections assume the admin role with SET ROLE, if one is configured.
+// The admin role is granted xtenancy_rls_bypass, so that the cross-tenant operations of the admin commands
+// see and write the rows of every tenant despite row level security
+func OpenAdminDb(vars Vars) (*sql.DB, error) {
+	return openDbWithCredentials(vars, NewCredentialProvider(vars), "", vars.AdminRole)
+}
+
+func openDbWithCredentials(vars Vars, provider CredentialProvider, params string, role string) (*sql.DB, error) {
+	vars.User = ""
+	vars.Password = ""
+
+	d := sql.OpenDB(&connector{
+		dsn:      strings.TrimSpace(MakeUrl(vars) + " " + params),
+		provider: provider,
+		role:     role,
+	})
+
+	d.SetMaxOpenConns(vars.MaxOpenConns)
+	d.SetMaxIdleConns(vars.MaxIdleConns)
+	d.SetConnMaxLifetime(vars.ConnMaxLifetime)
+
+	return d, nil
+}
+
+// connector is a driver.Connector that adds the credentials of a provider to a connection string
+// and makes its connections assume a role, if it has one
+type connector struct {
+	dsn      string
+	provider CredentialProvider
+	role     string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx, false)
+
+	if isAuthFailure(err) {
+		conn, err = c.connect(ctx, true)
+	}
+
+	if err != nil || c.role == "" {
+		return conn, err
+	}
+
+	if err := setRole(ctx, conn, c.role); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// setRole makes a connection assume a role for the rest of its session
+func setRole(ctx context.Context, conn driver.Conn, role string) error {
+	execer, ok := conn.(driver.ExecerContext)
+
+	if !ok {
+		return fmt.Errorf("failed to assume role %s: the driver cannot execute statements on a connection", role)
+	}
+
+	if _, err := execer.ExecContext(ctx, "set role "+pq.QuoteIdentifier(role), nil); err != nil {
+		return fmt.Errorf("failed to assume role %s: %w", role, err)
+	}
+
+	return nil
+}
+
+func (c *connector) connect(ctx context.Context, refresh bool) (driver.Conn, error) {
+	user, password, err := c.provider.Credentials(ctx, refresh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := c.dsn
+
+	if user != "" {
+		dsn += " user=" + quoteParam(user)
+	}
+
+	if password != "" {
+		dsn += " password=" + quoteParam(password)
+	}
+
+	pc, err := pq.NewConnector(strings.TrimSpace(dsn))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pc.Connect(ctx)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func isAuthFailure(err error) bool {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Code == pqInvalidPassword || e.Code == pqInvalidAuthorization
+	case pq.Error:
+		return e.Code == pqInvalidPassword || e.Code == pqInvalidAuthorization
+	}
+
+	return false
+}
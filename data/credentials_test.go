@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "password")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	assert.Nil(t, ioutil.WriteFile(f.Name(), []byte("first\n"), 0600))
+
+	vars := Vars{User: "postgres", Password: "ignored", PasswordFile: f.Name()}
+	provider := NewCredentialProvider(vars)
+
+	user, password, err := provider.Credentials(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres", user)
+	assert.Equal(t, "first", password)
+
+	// the password is rotated, the cached one is used until it is refreshed
+	assert.Nil(t, ioutil.WriteFile(f.Name(), []byte("second\n"), 0600))
+
+	_, password, _ = provider.Credentials(context.Background(), false)
+	assert.Equal(t, "first", password)
+
+	_, password, _ = provider.Credentials(context.Background(), true)
+	assert.Equal(t, "second", password)
+}
+
+func TestIsAuthFailure(t *testing.T) {
+	assert.True(t, isAuthFailure(&pq.Error{Code: pqInvalidPassword}))
+	assert.True(t, isAuthFailure(pq.Error{Code: pqInvalidAuthorization}))
+	assert.False(t, isAuthFailure(&pq.Error{Code: "42P01"}))
+	assert.False(t, isAuthFailure(nil))
+}
@@ -1,56 +1,239 @@
 package data
 
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Code is a stable, machine-readable error code
+type Code string
+
+// error codes
+const (
+	CodeNotFound        Code = "not_found"
+	CodeConflict        Code = "conflict"
+	CodeValidation      Code = "validation"
+	CodeUnauthenticated Code = "unauthenticated"
+	CodeForbidden       Code = "forbidden"
+	CodePrecondition    Code = "precondition_failed"
+	CodeUnknown         Code = "unknown"
+)
+
+// sentinel errors of each kind, every Error matches the sentinel of its code with errors.Is
+var (
+	ErrNotFound        = &Error{Code: CodeNotFound, Msg: "not found"}
+	ErrConflict        = &Error{Code: CodeConflict, Msg: "conflict"}
+	ErrValidation      = &Error{Code: CodeValidation, Msg: "validation failed"}
+	ErrUnauthenticated = &Error{Code: CodeUnauthenticated, Msg: "unauthenticated"}
+	ErrForbidden       = &Error{Code: CodeForbidden, Msg: "forbidden"}
+	ErrPrecondition    = &Error{Code: CodePrecondition, Msg: "precondition failed"}
+)
+
+var sentinels = []*Error{ErrNotFound, ErrConflict, ErrValidation, ErrUnauthenticated, ErrForbidden, ErrPrecondition}
+
+// Error is an error of the data store layer with a message that does not contain sensitive database implementation details.
+// The underlying error, if any, is available through Unwrap
 type Error struct {
-	Err error
-	Msg string
+	Code Code
+	Msg  string
+	// Constraint is the name of the database constraint that was violated, if any
+	Constraint string
+	Err        error
+}
+
+// NewError creates an Error from one of the data store layer error messages
+func NewError(msg string) error {
+	code, ok := storeCodes[msg]
+
+	if !ok {
+		code = CodeUnknown
+	}
+
+	return &Error{Code: code, Msg: msg}
 }
 
-// NewDbError creates an Error that hides database error details behind Unwrap
+// newErrorf creates an Error with the code of a data store layer error message and a message with details appended
+func newErrorf(msg string, detail string) error {
+	err := NewError(msg).(*Error)
+	err.Msg = msg + ": " + detail
+	return err
+}
+
+// NewDbError classifies a database error by its SQLSTATE and constraint name.
+// Errors that are already classified are returned unchanged.
+// A foreign key violation is taken to come from writing a missing reference, see newTableDbError
 func NewDbError(err error) error {
+	return newTableDbError(err, "")
+}
+
+// newTableDbError classifies the database error of a statement that wrote to table.
+// Postgres reports a foreign key violation on the table that holds the foreign key, so a violation on table itself
+// means the statement wrote a reference to a row that does not exist, and a violation on another table means
+// the statement deleted or changed a row that the other table still references
+func newTableDbError(err error, table string) error {
 	if err == nil {
 		return nil
 	}
 
-	if includes(storeMessages, err.Error()) {
+	var e *Error
+
+	if errors.As(err, &e) {
 		return err
 	}
 
-	msg := dbMessages[err.Error()]
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Code: CodeNotFound, Msg: ErrResourceDNE, Err: err}
+	}
+
+	var pqErr *pq.Error
+
+	if !errors.As(err, &pqErr) {
+		return &Error{Code: CodeUnknown, Msg: ErrUnknown, Err: err}
+	}
 
-	if msg == "" {
-		msg = ErrUnknown
+	e = &Error{
+		Code:       CodeUnknown,
+		Msg:        ErrUnknown,
+		Constraint: pqErr.Constraint,
+		Err:        err,
 	}
 
-	return &Error{err, msg}
+	switch {
+	case pqErr.Code == pqUniqueViolation:
+		e.Code = CodeConflict
+		e.Msg = ErrAlreadyExists
+	case pqErr.Code == pqForeignKeyViolation && table != "" && pqErr.Table != strings.Trim(table, `"`):
+		e.Code = CodePrecondition
+		e.Msg = ErrStillReferenced
+	case pqErr.Code == pqForeignKeyViolation:
+		e.Code = CodeNotFound
+		e.Msg = ErrReferenceDNE
+	case pqErr.Code == pqInsufficientPrivilege && pqErr.Routine == pqRowSecurityRoutine:
+		e.Code = CodeForbidden
+		e.Msg = ErrForbiddenTenant
+	case pqErr.Code == pqSerializationFailure:
+		e.Code = CodeConflict
+		e.Msg = ErrConcurrentUpdate
+	case pqErr.Code.Class() == pqClassDataException || pqErr.Code.Class() == pqClassIntegrityViolation:
+		e.Code = CodeValidation
+		e.Msg = ErrInvalidValue
+	}
+
+	if msg, ok := constraintMessages[pqErr.Constraint]; ok && e.Code != CodePrecondition {
+		e.Msg = msg
+	}
+
+	return e
 }
 
-func (e Error) Error() string {
+func (e *Error) Error() string {
 	return e.Msg
 }
 
-func (e Error) Unwrap() error {
+func (e *Error) Unwrap() error {
 	return e.Err
 }
 
+// Is reports whether target is the sentinel error of e's code
+func (e *Error) Is(target error) bool {
+	for _, s := range sentinels {
+		if target == s {
+			return e.Code == s.Code
+		}
+	}
+
+	return false
+}
+
+// ErrorCode returns the code of an error, or CodeUnknown if it is not an Error
+func ErrorCode(err error) Code {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return CodeUnknown
+}
+
 // error messages that originate from the data store layer that do not contain sensitive database implementation details
 const ErrResourceDNE = "resource does not exist"
 const ErrEmptyFieldMask = "field mask is empty"
 const ErrAlreadyMember = "user is already member of tenant"
-var storeMessages = []string{
-	ErrEmptyFieldMask,
-	ErrResourceDNE,
+const ErrTenantCycle = "tenant cannot be nested under itself or its descendants"
+const ErrInvalidApiKey = "api key is invalid or revoked"
+const ErrInvitationClosed = "invitation has already been accepted or revoked"
+const ErrInvitationExpired = "invitation has expired"
+const ErrInvitationUserDNE = "no user has the invited email address"
+const ErrArchiveVersion = "unsupported tenant archive version"
+const ErrArchiveIncomplete = "tenant archive references records it does not contain"
+const ErrUserConflict = "a user with the same email address or auth id already exists"
+const ErrVersionMismatch = "resource was modified since it was read"
+const ErrTenantNotEmpty = "tenant still has members or joinrequests, remove them first"
+
+// storeCodes classifies the data store layer error messages
+var storeCodes = map[string]Code{
+	ErrResourceDNE:       CodeNotFound,
+	ErrEmptyFieldMask:    CodeValidation,
+	ErrAlreadyMember:     CodeConflict,
+	ErrTenantCycle:       CodePrecondition,
+	ErrInvalidApiKey:     CodeForbidden,
+	ErrInvitationClosed:  CodePrecondition,
+	ErrInvitationExpired: CodePrecondition,
+	ErrInvitationUserDNE: CodePrecondition,
+	ErrArchiveVersion:    CodeValidation,
+	ErrArchiveIncomplete: CodeValidation,
+	ErrUserConflict:      CodeConflict,
+	ErrVersionMismatch:   CodeConflict,
+	ErrTenantNotEmpty:    CodePrecondition,
 }
 
-// error messages that originate from the database and contain potentially sensitive database implementation details
-const DbErrGroupUserAlreadyLinked = "pq: duplicate key value violates unique constraint \"group_user_pkey\""
-const ErrGroupUserAlreadyLinked = "group already linked to user"
-const DbErrGroupOrUserDNE = "pq: insert or update on table \"group_user\" violates foreign key constraint \"group_user_group_id_fkey\""
-const ErrGroupOrUserDNE = "group or user does not exist"
-var dbMessages = map[string]string{
-	ErrResourceDNE: ErrResourceDNE,
-	DbErrGroupOrUserDNE: ErrGroupOrUserDNE,
-	DbErrGroupUserAlreadyLinked: ErrGroupUserAlreadyLinked,
+// error messages of database errors, by kind
+const ErrAlreadyExists = "resource already exists"
+const ErrStillReferenced = "resource is still referenced by other resources"
+const ErrReferenceDNE = "referenced resource does not exist"
+const ErrForbiddenTenant = "resource belongs to another tenant"
+const ErrConcurrentUpdate = "resource was updated concurrently"
+const ErrInvalidValue = "invalid value"
+
+// error messages of violations of specific database constraints
+const ErrEmailTaken = "email address is already in use"
+const ErrAuthIdTaken = "auth id is already in use"
+const ErrServiceAccountNameTaken = "tenant already has a service account with the same name"
+const ErrTenantDNE = "tenant does not exist"
+const ErrUserDNE = "user does not exist"
+const ErrOwnerDNE = "owner does not exist"
+const ErrParentTenantDNE = "parent tenant does not exist"
+const ErrServiceAccountDNE = "service account does not exist"
+
+var constraintMessages = map[string]string{
+	"user_email_key":                     ErrEmailTaken,
+	"user_auth_id_key":                   ErrAuthIdTaken,
+	"member_tenant_id_user_id_key":       ErrAlreadyMember,
+	"service_account_tenant_id_name_key": ErrServiceAccountNameTaken,
+	"tenant_owner_id_fkey":               ErrOwnerDNE,
+	"tenant_parent_id_fkey":              ErrParentTenantDNE,
+	"member_tenant_id_fkey":              ErrTenantDNE,
+	"member_user_id_fkey":                ErrUserDNE,
+	"joinrequest_tenant_id_fkey":         ErrTenantDNE,
+	"joinrequest_user_id_fkey":           ErrUserDNE,
+	"service_account_tenant_id_fkey":     ErrTenantDNE,
+	"api_key_service_account_id_fkey":    ErrServiceAccountDNE,
 }
 
+// postgres SQLSTATE codes and classes
+const pqUniqueViolation = "23505"
+const pqForeignKeyViolation = "23503"
+const pqInsufficientPrivilege = "42501"
+const pqSerializationFailure = "40001"
+const pqClassDataException = "22"
+const pqClassIntegrityViolation = "23"
+
+// the postgres routine that reports rows that violate a row level security policy.
+// Other insufficient privilege errors, e.g. a missing grant, are a misconfiguration rather than a forbidden request
+const pqRowSecurityRoutine = "ExecWithCheckOptions"
+
 // fallthrough error message
 const ErrUnknown = "unspecified database error"
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewDbError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code Code
+		msg  string
+		is   error
+	}{
+		{&pq.Error{Code: "23505", Constraint: "user_email_key"}, CodeConflict, ErrEmailTaken, ErrConflict},
+		{&pq.Error{Code: "23505", Constraint: "member_tenant_id_user_id_key"}, CodeConflict, ErrAlreadyMember, ErrConflict},
+		{&pq.Error{Code: "23505", Constraint: "some_other_key"}, CodeConflict, ErrAlreadyExists, ErrConflict},
+		{&pq.Error{Code: "23503", Table: "member", Constraint: "member_user_id_fkey"}, CodeNotFound, ErrUserDNE, ErrNotFound},
+		{&pq.Error{Code: "22P02"}, CodeValidation, ErrInvalidValue, ErrValidation},
+		{&pq.Error{Code: "23502"}, CodeValidation, ErrInvalidValue, ErrValidation},
+		{&pq.Error{Code: "42501", Routine: "ExecWithCheckOptions"}, CodeForbidden, ErrForbiddenTenant, ErrForbidden},
+		{&pq.Error{Code: "42501", Routine: "aclcheck_error"}, CodeUnknown, ErrUnknown, nil},
+		{sql.ErrNoRows, CodeNotFound, ErrResourceDNE, ErrNotFound},
+		{errors.New("connection refused"), CodeUnknown, ErrUnknown, nil},
+	}
+
+	for _, test := range tests {
+		err := NewDbError(test.err)
+
+		var e *Error
+		assert.True(t, errors.As(err, &e))
+		assert.Equal(t, test.code, e.Code, test.err.Error())
+		assert.Equal(t, test.msg, e.Error(), test.err.Error())
+		assert.True(t, errors.Is(err, test.err))
+
+		if test.is != nil {
+			assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", err), test.is))
+		}
+
+		for _, s := range sentinels {
+			if s != test.is {
+				assert.False(t, errors.Is(err, s))
+			}
+		}
+	}
+
+	assert.Nil(t, NewDbError(nil))
+
+	// the direction of a foreign key violation follows from the table that was written
+	fk := &pq.Error{Code: "23503", Table: "member", Constraint: "member_user_id_fkey"}
+
+	err := newTableDbError(fk, "member")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, ErrUserDNE, err.Error())
+
+	err = newTableDbError(fk, `"user"`)
+	assert.True(t, errors.Is(err, ErrPrecondition))
+	assert.Equal(t, ErrStillReferenced, err.Error())
+	assert.True(t, errors.Is(err, fk))
+
+	classified := NewError(ErrResourceDNE)
+	assert.Equal(t, classified, NewDbError(classified))
+	assert.True(t, errors.Is(classified, ErrNotFound))
+	assert.Equal(t, CodeNotFound, ErrorCode(classified))
+	assert.Equal(t, CodeUnknown, ErrorCode(errors.New(ErrResourceDNE)))
+}
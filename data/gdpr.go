@@ -0,0 +1,166 @@
+package data
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// the values that replace the personal data of an erased user
+const erasedName = "erased"
+const erasedEmailDomain = "erased.invalid"
+
+// UserDataExport is everything stored about a user, for answering data subject access requests
+type UserDataExport struct {
+	ExportedAt   time.Time      `json:"exportedAt"`
+	User         *User          `json:"user"`
+	Members      []*Member      `json:"members"`
+	Joinrequests []*Joinrequest `json:"joinrequests"`
+	// Invitations are the joinrequests addressed to the user's email address rather than to the user
+	Invitations []*Joinrequest `json:"invitations"`
+	// OwnedTenants are the tenants the user owns
+	OwnedTenants []*Tenant `json:"ownedTenants"`
+}
+
+// ErasedEmail returns the address that replaces the email address of an erased user
+func ErasedEmail(userId string) string {
+	return fmt.Sprintf("%s@%s", userId, erasedEmailDomain)
+}
+
+// ExportUserData gets everything stored about a user
+func (s *Store) ExportUserData(userId string) (_ *UserDataExport, err error) {
+	s, end := s.observe("ExportUserData")
+	defer end(&err)
+
+	u, err := s.GetUser(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return nil, NewError(ErrResourceDNE)
+	}
+
+	export := &UserDataExport{
+		ExportedAt: time.Now().UTC(),
+		User:       u,
+	}
+
+	_, err = s.db.
+		Select("*").
+		From("tenant").
+		Where("owner_id = ?", userId).
+		OrderBy("name").
+		LoadContext(s.context(), &export.OwnedTenants)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	err = s.eachTenantScope(func(s *Store) error {
+		m, err := s.GetMembersByUserId(userId)
+
+		if err != nil {
+			return err
+		}
+
+		jr, err := s.GetJoinrequestsByUserId(userId)
+
+		if err != nil {
+			return err
+		}
+
+		invitations, err := s.GetJoinrequestsByAnonEmail(u.Email)
+
+		if err != nil {
+			return err
+		}
+
+		export.Members = append(export.Members, m...)
+		export.Joinrequests = append(export.Joinrequests, jr...)
+		export.Invitations = append(export.Invitations, invitations...)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// EraseUser anonymizes the personal data of a user.
+// The user row is kept, so that memberships, tenant ownership and records that reference the user stay intact,
+// but its email address, names and external identity are replaced, member aliases are cleared
+// and joinrequests addressed to the user's email address are readdressed to the anonymized address
+func (s *Store) EraseUser(userId string) (err error) {
+	s, end := s.observe("EraseUser")
+	defer end(&err)
+
+	return s.transaction(func(s *Store) error {
+		u, err := s.GetUser(userId)
+
+		if err != nil {
+			return err
+		}
+
+		if u == nil {
+			return NewError(ErrResourceDNE)
+		}
+
+		erasedEmail := ErasedEmail(userId)
+
+		err = s.update("user", userId, AnyVersion, []string{"AuthId", "Email", "FirstName", "LastName"},
+			set{"AuthId", "auth_id", uuid.New().String()},
+			set{"Email", "email", erasedEmail},
+			set{"FirstName", "first_name", erasedName},
+			set{"LastName", "last_name", erasedName},
+		)
+
+		if err != nil {
+			return NewDbError(err)
+		}
+
+		return s.eachTenantScope(func(s *Store) error {
+			_, err := s.db.
+				Update("member").
+				Set("alias", nil).
+				SetMap(s.updateStamp()).
+				Where("user_id = ?", userId).
+				ExecContext(s.context())
+
+			if err != nil {
+				return NewDbError(err)
+			}
+
+			_, err = s.db.
+				Update("joinrequest").
+				Set("anon_email", erasedEmail).
+				SetMap(s.updateStamp()).
+				Where("anon_email = ?", u.Email).
+				ExecContext(s.context())
+
+			return NewDbError(err)
+		})
+	})
+}
+
+// eachTenantScope calls fn once per tenant with the store confined to the tenant, see TenantStore.
+// Row level security hides the rows of every tenant from a store that is not confined to one,
+// unless it connects as a role granted xtenancy_rls_bypass
+func (s *Store) eachTenantScope(fn func(s *Store) error) error {
+	tenants, err := s.GetTenants()
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		if err := s.ForTenant(t.Id).transaction(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"errors"
+	"github.com/gocraft/dbr/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// QueryReceiver is a dbr.EventReceiver that logs slow queries and records the duration and errors of queries
+// as Prometheus metrics labelled by statement name, e.g. "select member" or "update tenant"
+type QueryReceiver struct {
+	logger        logrus.FieldLogger
+	slowThreshold time.Duration
+	durations     *prometheus.HistogramVec
+	errors        *prometheus.CounterVec
+}
+
+// ReceiverOptions configures a QueryReceiver
+type ReceiverOptions struct {
+	// Logger receives the slow and failed query logs. It defaults to the logrus standard logger
+	Logger logrus.FieldLogger
+	// SlowThreshold is the duration from which a query is logged as slow. Zero disables slow query logs
+	SlowThreshold time.Duration
+	// Registerer registers the query metrics. It defaults to prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+}
+
+// NewQueryReceiver creates a QueryReceiver and registers its metrics.
+// Metrics that are already registered, e.g. by another store of the same process, are shared
+func NewQueryReceiver(opts ReceiverOptions) (*QueryReceiver, error) {
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xtenancy_db_query_duration_seconds",
+		Help:    "Duration of database queries by statement name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"statement"})
+
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xtenancy_db_query_errors_total",
+		Help: "Failed database queries by statement name and error code.",
+	}, []string{"statement", "code"})
+
+	c, err := register(opts.Registerer, durations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	durations = c.(*prometheus.HistogramVec)
+
+	if c, err = register(opts.Registerer, errs); err != nil {
+		return nil, err
+	}
+
+	errs = c.(*prometheus.CounterVec)
+
+	return &QueryReceiver{
+		logger:        opts.Logger,
+		slowThreshold: opts.SlowThreshold,
+		durations:     durations,
+		errors:        errs,
+	}, nil
+}
+
+// register registers a collector. If an equal collector is already registered, that collector is returned instead
+func register(r prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	err := r.Register(c)
+	var are prometheus.AlreadyRegisteredError
+
+	if errors.As(err, &are) {
+		return are.ExistingCollector, nil
+	}
+
+	return c, err
+}
+
+// withLogger returns a copy of the receiver that logs to l and shares the receiver's metrics
+func (r *QueryReceiver) withLogger(l logrus.FieldLogger) *QueryReceiver {
+	c := *r
+	c.logger = l
+	return &c
+}
+
+// Event implements dbr.EventReceiver
+func (r *QueryReceiver) Event(eventName string) {}
+
+// EventKv implements dbr.EventReceiver
+func (r *QueryReceiver) EventKv(eventName string, kvs map[string]string) {}
+
+// EventErr implements dbr.EventReceiver
+func (r *QueryReceiver) EventErr(eventName string, err error) error {
+	return r.EventErrKv(eventName, err, nil)
+}
+
+// EventErrKv counts a failed query. Loading no row into a single value is not counted as an error
+func (r *QueryReceiver) EventErrKv(eventName string, err error, kvs map[string]string) error {
+	if err == dbr.ErrNotFound {
+		return err
+	}
+
+	statement := statementName(kvs["sql"])
+	code := ErrorCode(NewDbError(err))
+
+	r.errors.WithLabelValues(statement, string(code)).Inc()
+
+	r.logger.WithFields(logrus.Fields{
+		"event":     eventName,
+		"statement": statement,
+		"code":      code,
+		"error":     err.Error(),
+	}).Debug("query failed")
+
+	return err
+}
+
+// Timing implements dbr.EventReceiver
+func (r *QueryReceiver) Timing(eventName string, nanoseconds int64) {
+	r.TimingKv(eventName, nanoseconds, nil)
+}
+
+// TimingKv records the duration of a query and logs it if it is slow, with its values replaced by placeholders
+func (r *QueryReceiver) TimingKv(eventName string, nanoseconds int64, kvs map[string]string) {
+	d := time.Duration(nanoseconds)
+	statement := statementName(kvs["sql"])
+
+	r.durations.WithLabelValues(statement).Observe(d.Seconds())
+
+	if r.slowThreshold <= 0 || d < r.slowThreshold {
+		return
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"event":      eventName,
+		"statement":  statement,
+		"durationMs": d.Milliseconds(),
+		"sql":        sanitizeSql(kvs["sql"]),
+	}).Warn("slow query")
+}
+
+// statementName names a query by its verb and the table it reads or writes, e.g. "select member".
+// Queries that cannot be named this way are named by their verb alone
+func statementName(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+
+	if len(words) == 0 {
+		return "unknown"
+	}
+
+	verb := words[0]
+	var after string
+
+	switch verb {
+	case "select", "delete":
+		after = "from"
+	case "insert":
+		after = "into"
+	case "update":
+		if len(words) > 1 {
+			return verb + " " + tableName(words[1])
+		}
+
+		return verb
+	default:
+		return verb
+	}
+
+	for i, w := range words[:len(words)-1] {
+		if w == after {
+			return verb + " " + tableName(words[i+1])
+		}
+	}
+
+	return verb
+}
+
+// tableName strips the quotes, schema and trailing punctuation from a table reference
+func tableName(ref string) string {
+	ref = strings.TrimRight(ref, ",;()")
+
+	if i := strings.LastIndex(ref, "."); i >= 0 {
+		ref = ref[i+1:]
+	}
+
+	return strings.Trim(ref, `"`)
+}
+
+// OperationObserver is notified of the operations of a Store, e.g. CreateUser or TenantStore.GetMembers
+type OperationObserver interface {
+	// StartOperation is called when an operation starts.
+	// It returns a function that is called with the operation's error, or nil, when the operation ends
+	StartOperation(op string) func(err error)
+}
+
+// WithObserver makes a Store notify an observer of its operations
+func WithObserver(o OperationObserver) StoreOption {
+	return func(s *Store) {
+		s.observer = o
+	}
+}
+
+// observe notifies the store's observer that an operation starts and traces the operation.
+// It returns the store that runs the operation, whose statements are traced as children of the operation,
+// and a function that is deferred with a pointer to the operation's error
+func (s *Store) observe(op string) (*Store, func(err *error)) {
+	end := func(err error) {}
+
+	if s.observer != nil {
+		end = s.observer.StartOperation(op)
+	}
+
+	s, endSpan := s.startSpan(op)
+
+	return s, func(err *error) {
+		endSpan(*err)
+		end(*err)
+	}
+}
+
+// StoreMetrics is an OperationObserver that counts the operations of a Store and their errors as Prometheus metrics
+type StoreMetrics struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+}
+
+// NewStoreMetrics creates a StoreMetrics and registers its metrics with r, or prometheus.DefaultRegisterer if r is nil.
+// Metrics that are already registered are shared
+func NewStoreMetrics(r prometheus.Registerer) (*StoreMetrics, error) {
+	if r == nil {
+		r = prometheus.DefaultRegisterer
+	}
+
+	operations := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xtenancy_store_operations_total",
+		Help: "Store operations by operation name.",
+	}, []string{"operation"})
+
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xtenancy_store_operation_errors_total",
+		Help: "Failed store operations by operation name and error code.",
+	}, []string{"operation", "code"})
+
+	c, err := register(r, operations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	operations = c.(*prometheus.CounterVec)
+
+	if c, err = register(r, errs); err != nil {
+		return nil, err
+	}
+
+	errs = c.(*prometheus.CounterVec)
+
+	return &StoreMetrics{
+		operations: operations,
+		errors:     errs,
+	}, nil
+}
+
+// StartOperation counts an operation and, when it ends, its error
+func (m *StoreMetrics) StartOperation(op string) func(err error) {
+	m.operations.WithLabelValues(op).Inc()
+
+	return func(err error) {
+		if err != nil {
+			m.errors.WithLabelValues(op, string(ErrorCode(err))).Inc()
+		}
+	}
+}
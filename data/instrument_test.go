@@ -0,0 +1,89 @@
+package data
+
+import (
+	"github.com/gocraft/dbr/v2"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStatementName(t *testing.T) {
+	assert.Equal(t, "select member", statementName(`SELECT * FROM member WHERE (id = 'a')`))
+	assert.Equal(t, "select user", statementName(`SELECT count(*) FROM "user" WHERE (id = 'a')`))
+	assert.Equal(t, "select member", statementName(`SELECT * FROM "tenant_0a".member`))
+	assert.Equal(t, "insert tenant", statementName(`INSERT INTO "tenant" ("id","name") VALUES ('a','b')`))
+	assert.Equal(t, "update api_key", statementName(`UPDATE "api_key" SET "revoked_at" = now()`))
+	assert.Equal(t, "delete joinrequest", statementName(`DELETE FROM "joinrequest" WHERE (id = 'a')`))
+	assert.Equal(t, "create", statementName(`create schema if not exists "tenant_0a"`))
+	assert.Equal(t, "unknown", statementName(""))
+}
+
+func TestQueryReceiver(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	registry := prometheus.NewRegistry()
+
+	r, err := NewQueryReceiver(ReceiverOptions{
+		Logger:        logger,
+		SlowThreshold: 100 * time.Millisecond,
+		Registerer:    registry,
+	})
+	assert.Nil(t, err)
+
+	r.TimingKv("dbr.select", int64(10*time.Millisecond), map[string]string{"sql": `SELECT * FROM tenant`})
+	assert.Nil(t, hook.LastEntry())
+
+	r.TimingKv("dbr.select", int64(150*time.Millisecond), map[string]string{"sql": `SELECT * FROM tenant`})
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "slow query", hook.LastEntry().Message)
+	assert.Equal(t, "select tenant", hook.LastEntry().Data["statement"])
+	assert.Equal(t, int64(150), hook.LastEntry().Data["durationMs"])
+
+	// the values that dbr interpolated are kept out of the log
+	r.TimingKv("dbr.select", int64(150*time.Millisecond), map[string]string{"sql": `SELECT * FROM "user" WHERE email = 'jane@example.com'`})
+	assert.Equal(t, `SELECT * FROM "user" WHERE email = ?`, hook.LastEntry().Data["sql"])
+	assert.Equal(t, 2, testutil.CollectAndCount(r.durations))
+
+	dupErr := &pq.Error{Code: "23505"}
+	sql := map[string]string{"sql": `INSERT INTO "user" ("id") VALUES ('a')`}
+	assert.Equal(t, dupErr, r.EventErrKv("dbr.exec.exec", dupErr, sql))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.errors.WithLabelValues("insert user", string(CodeConflict))))
+	assert.Equal(t, "query failed", hook.LastEntry().Message)
+
+	assert.Equal(t, dbr.ErrNotFound, r.EventErrKv("dbr.select.load.scan", dbr.ErrNotFound, sql))
+	assert.Equal(t, 1, testutil.CollectAndCount(r.errors))
+
+	// a second receiver of the same registry shares the registered metrics
+	r2, err := NewQueryReceiver(ReceiverOptions{Registerer: registry})
+	assert.Nil(t, err)
+	assert.Same(t, r.errors, r2.errors)
+}
+
+func TestStoreMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewStoreMetrics(registry)
+	assert.Nil(t, err)
+
+	s := &Store{observer: m}
+
+	op := func(fail error) (err error) {
+		_, end := s.observe("GetUser")
+		defer end(&err)
+		return fail
+	}
+
+	assert.Nil(t, op(nil))
+	assert.NotNil(t, op(NewError(ErrResourceDNE)))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.operations.WithLabelValues("GetUser")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("GetUser", string(CodeNotFound))))
+
+	// a store without an observer is not observed
+	s = &Store{}
+	assert.Nil(t, op(nil))
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// tenant isolation modes
+const IsolationShared = "shared"
+const IsolationSchema = "schema"
+
+const tenantSchemaPrefix = "tenant_"
+
+// StoreOption configures optional behaviour of a Store
+type StoreOption func(s *Store)
+
+// WithSchemaIsolation makes a Store give each tenant its own Postgres schema for its tenant-scoped tables.
+// The vars are used to open the connections that migrate tenant schemas
+func WithSchemaIsolation(vars Vars) StoreOption {
+	return func(s *Store) {
+		s.isolation = IsolationSchema
+		s.vars = vars
+	}
+}
+
+// TenantSchemaName returns the name of the schema that holds the tenant-scoped tables of a schema isolated tenant
+func TenantSchemaName(tenantId string) string {
+	return tenantSchemaPrefix + strings.ReplaceAll(tenantId, "-", "")
+}
+
+// ProvisionTenantSchema creates the schema of a tenant, if it does not exist, and migrates it to the latest version.
+// A new schema is created in the store's transaction, if it is bound to one
+func (s *Store) ProvisionTenantSchema(tenantId string) (err error) {
+	s, end := s.observe("ProvisionTenantSchema")
+	defer end(&err)
+
+	schema := TenantSchemaName(tenantId)
+
+	var exists bool
+
+	err = s.db.
+		SelectBySql("select exists(select 1 from information_schema.schemata where schema_name = ?)", schema).
+		LoadOneContext(s.context(), &exists)
+
+	if err != nil {
+		return NewDbError(err)
+	}
+
+	if !exists {
+		return s.transaction(func(s *Store) error {
+			return s.createTenantSchema(schema)
+		})
+	}
+
+	// an existing schema may lag behind, it is migrated like the tenant schemas of "migrate up"
+	err = migrateTenantSchema(s.vars, schema, func(schema string, m *migrate.Migrate) error {
+		err := m.Up()
+
+		if err == migrate.ErrNoChange {
+			return nil
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to provision tenant schema %s: %w", schema, err)
+	}
+
+	return nil
+}
+
+// createTenantSchema creates a tenant schema and applies the tenant migrations to it in the store's transaction,
+// so that the schema is discarded if the transaction is rolled back.
+// The applied version is recorded the way golang-migrate records it, so that "migrate up" continues from there
+func (s *Store) createTenantSchema(schema string) error {
+	scripts, err := readUpMigrations(s.vars.MigrationsDir, "tenant", 0)
+
+	if err != nil {
+		return fmt.Errorf("failed to provision tenant schema %s: %w", schema, err)
+	}
+
+	var searchPath string
+
+	if err := s.db.SelectBySql("select current_setting('search_path')").LoadOneContext(s.context(), &searchPath); err != nil {
+		return NewDbError(err)
+	}
+
+	quoted := pq.QuoteIdentifier(schema)
+	statements := []string{
+		"create schema " + quoted,
+		"set local search_path to " + quoted + ", public",
+	}
+
+	for _, script := range scripts {
+		statements = append(statements, script.Sql)
+	}
+
+	if len(scripts) > 0 {
+		table := quoted + "." + pq.QuoteIdentifier(postgres.DefaultMigrationsTable)
+
+		statements = append(statements,
+			"create table "+table+" (version bigint not null primary key, dirty boolean not null)",
+			fmt.Sprintf("insert into %s (version, dirty) values (%d, false)", table, scripts[len(scripts)-1].Version),
+		)
+	}
+
+	for _, statement := range statements {
+		if err := s.exec(statement); err != nil {
+			return fmt.Errorf("failed to provision tenant schema %s: %w", schema, NewDbError(err))
+		}
+	}
+
+	// the rest of the transaction resolves tables as before
+	_, err = s.db.SelectBySql("select set_config('search_path', ?, true)", searchPath).LoadContext(s.context(), &searchPath)
+	return NewDbError(err)
+}
+
+// dropTenantSchema drops the schema of a tenant and everything in it, in the store's transaction if it is bound to one
+func (s *Store) dropTenantSchema(tenantId string) error {
+	err := s.exec("drop schema if exists " + pq.QuoteIdentifier(TenantSchemaName(tenantId)) + " cascade")
+	return NewDbError(err)
+}
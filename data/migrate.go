@@ -1,31 +1,231 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
-	"path/filepath"
-	"runtime"
+	"github.com/lib/pq"
+	"strings"
 )
 
 // NewSchemaMigration creates a migration instance that can be used to
-// apply and rollback schema changes to a database
-func NewSchemaMigration(d *sql.DB, dbName string) (*migrate.Migrate, error) {
-	driver, err := postgres.WithInstance(d, &postgres.Config{})
+// apply and rollback schema changes to a database.
+// The migrations embedded in the binary are used unless dir names a migrations directory on disk
+func NewSchemaMigration(d *sql.DB, dbName string, dir string) (*migrate.Migrate, error) {
+	return newMigration(d, dbName, &postgres.Config{}, dir, "")
+}
+
+// NewTenantSchemaMigration creates a migration instance that can be used to
+// apply and rollback the tenant-scoped tables of a schema isolated tenant.
+// The search_path of d must resolve to the tenant schema first, see OpenTenantSchemaDb
+func NewTenantSchemaMigration(d *sql.DB, dbName string, schema string, dir string) (*migrate.Migrate, error) {
+	return newMigration(d, dbName, &postgres.Config{SchemaName: schema}, dir, "tenant")
+}
+
+// MigrateTenantSchemas calls fn with a migration instance for each provisioned tenant schema
+func MigrateTenantSchemas(d *sql.DB, vars Vars, fn func(schema string, m *migrate.Migrate) error) error {
+	schemas, err := TenantSchemas(d)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create migration db driver: %w", err)
+		return err
+	}
+
+	for _, schema := range schemas {
+		err := migrateTenantSchema(vars, schema, fn)
+
+		if err != nil {
+			return fmt.Errorf("failed to migrate tenant schema %s: %w", schema, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateTenantSchema calls fn with a migration instance for a single tenant schema
+func MigrateTenantSchema(vars Vars, schema string, fn func(schema string, m *migrate.Migrate) error) error {
+	err := migrateTenantSchema(vars, schema, fn)
+
+	if err != nil {
+		return fmt.Errorf("failed to migrate tenant schema %s: %w", schema, err)
+	}
+
+	return nil
+}
+
+func migrateTenantSchema(vars Vars, schema string, fn func(schema string, m *migrate.Migrate) error) error {
+	d, err := OpenTenantSchemaDb(vars, schema)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	m, err := NewTenantSchemaMigration(d, vars.Name, schema, vars.MigrationsDir)
+
+	if err != nil {
+		return err
+	}
+
+	return fn(schema, m)
+}
+
+// OpenTenantSchemaDb opens a database whose search_path resolves to a tenant schema before the public schema
+func OpenTenantSchemaDb(vars Vars, schema string) (*sql.DB, error) {
+	return openDbWithCredentials(vars, NewCredentialProvider(vars), "search_path="+quoteParam(schema+",public"), "")
+}
+
+// TenantSchemas lists the schemas of tenants provisioned with schema isolation
+func TenantSchemas(d *sql.DB) ([]string, error) {
+	return tenantSchemas(context.Background(), d)
+}
+
+func tenantSchemas(ctx context.Context, d *sql.DB) ([]string, error) {
+	rows, err := d.QueryContext(ctx, `
+		select schema_name from information_schema.schemata
+		where schema_name like $1
+		order by schema_name
+	`, strings.ReplaceAll(tenantSchemaPrefix, "_", `\_`)+"%")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant schemas: %w", err)
+	}
+
+	defer rows.Close()
+
+	var schemas []string
+
+	for rows.Next() {
+		var schema string
+
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("failed to list tenant schemas: %w", err)
+		}
+
+		schemas = append(schemas, schema)
 	}
 
-	_, path, _, _ := runtime.Caller(0)
+	return schemas, rows.Err()
+}
+
+// SchemaVersion reads the migration version of the public schema and whether its last migration failed halfway,
+// the way golang-migrate reports them, without creating a migration instance that would take over d.
+// migrate.ErrNilVersion is returned if no migration was applied
+func SchemaVersion(ctx context.Context, d *sql.DB) (uint, bool, error) {
+	return schemaVersion(ctx, d, pq.QuoteIdentifier(postgres.DefaultMigrationsTable))
+}
+
+// TenantSchemaVersion reads the migration version of a tenant schema and whether its last migration failed halfway,
+// see SchemaVersion
+func TenantSchemaVersion(ctx context.Context, d *sql.DB, schema string) (uint, bool, error) {
+	return schemaVersion(ctx, d, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(postgres.DefaultMigrationsTable))
+}
+
+// TenantSchemaVersions reads the migration version of every tenant schema provisioned with schema isolation.
+// Schemas without an applied migration have version 0
+func TenantSchemaVersions(ctx context.Context, d *sql.DB) (map[string]uint, error) {
+	schemas, err := TenantSchemas(d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]uint{}
+
+	for _, schema := range schemas {
+		v, _, err := TenantSchemaVersion(ctx, d, schema)
+
+		if err != nil && err != migrate.ErrNilVersion {
+			return nil, fmt.Errorf("failed to read the version of tenant schema %s: %w", schema, err)
+		}
+
+		versions[schema] = v
+	}
+
+	return versions, nil
+}
+
+// OutdatedTenantSchemas lists the tenant schemas provisioned with schema isolation that are not at the expected version,
+// including those without an applied migration and those whose last migration failed halfway.
+// The versions of all schemas are read in one query rather than one query per tenant
+func OutdatedTenantSchemas(ctx context.Context, d *sql.DB, expected uint) ([]string, error) {
+	schemas, err := tenantSchemas(ctx, d)
+
+	if err != nil || len(schemas) == 0 {
+		return nil, err
+	}
+
+	rows, err := d.QueryContext(ctx, outdatedTenantSchemasQuery(schemas), int64(expected))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the versions of the tenant schemas: %w", err)
+	}
+
+	defer rows.Close()
+
+	var outdated []string
+
+	for rows.Next() {
+		var i int
+
+		if err := rows.Scan(&i); err != nil {
+			return nil, fmt.Errorf("failed to read the versions of the tenant schemas: %w", err)
+		}
+
+		outdated = append(outdated, schemas[i])
+	}
+
+	return outdated, rows.Err()
+}
+
+// outdatedTenantSchemasQuery selects the indexes of the schemas whose migration version differs from $1 or is dirty.
+// The aggregates return a row of nulls for a schema without an applied migration
+func outdatedTenantSchemasQuery(schemas []string) string {
+	versions := make([]string, len(schemas))
+
+	for i, schema := range schemas {
+		table := pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(postgres.DefaultMigrationsTable)
+		versions[i] = fmt.Sprintf("select %d as i, max(version) as version, bool_or(dirty) as dirty from %s", i, table)
+	}
+
+	return "select i from (" + strings.Join(versions, " union all ") + ") versions " +
+		"where version is distinct from $1 or dirty is not false order by i"
+}
+
+func schemaVersion(ctx context.Context, d *sql.DB, table string) (uint, bool, error) {
+	var version int64
+	var dirty bool
+
+	query := "select version, dirty from " + table + " limit 1"
+	err := d.QueryRowContext(ctx, query).Scan(&version, &dirty)
+
+	if err == sql.ErrNoRows {
+		return 0, false, migrate.ErrNilVersion
+	}
+
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return uint(version), dirty, nil
+}
+
+func newMigration(d *sql.DB, dbName string, config *postgres.Config, dir string, subdir string) (*migrate.Migrate, error) {
+	src, err := newMigrationSource(dir, subdir)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration source: %w", err)
+	}
+
+	driver, err := postgres.WithInstance(d, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration db driver: %w", err)
+	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://"+filepath.Dir(path)+"/migrations",
-		dbName, driver,
-	)
+	m, err := migrate.NewWithInstance("migrations", src, dbName, driver)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create migration instance: %w", err)
@@ -0,0 +1,16 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOutdatedTenantSchemasQuery(t *testing.T) {
+	query := outdatedTenantSchemasQuery([]string{"tenant_a", "tenant_b"})
+
+	expected := `select i from (` +
+		`select 0 as i, max(version) as version, bool_or(dirty) as dirty from "tenant_a"."schema_migrations" union all ` +
+		`select 1 as i, max(version) as version, bool_or(dirty) as dirty from "tenant_b"."schema_migrations"` +
+		`) versions where version is distinct from $1 or dirty is not false order by i`
+	assert.Equal(t, expected, query)
+}
@@ -0,0 +1,330 @@
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// migration lint rules
+const LintBlockingIndex = "blocking-index"
+const LintColumnTypeChange = "column-type-change"
+const LintNotNullWithoutDefault = "not-null-without-default"
+const LintSetNotNull = "set-not-null"
+const LintDropReferencedColumn = "drop-referenced-column"
+
+// LintFinding is an operation in a migration that can lock a busy table
+type LintFinding struct {
+	File    string
+	Line    int
+	Rule    string
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s:%d %s: %s", f.File, f.Line, f.Rule, f.Message)
+}
+
+// tableModels maps tables to the models whose db tags reference their columns
+var tableModels = map[string]interface{}{
+	"user":            User{},
+	"tenant":          Tenant{},
+	"joinrequest":     Joinrequest{},
+	"member":          Member{},
+	"service_account": ServiceAccount{},
+	"api_key":         ApiKey{},
+}
+
+var (
+	lintIgnorePattern     = regexp.MustCompile(`--\s*lint:ignore(-file)?\s+([\w-]+(?:\s*,\s*[\w-]+)*)`)
+	createTablePattern    = regexp.MustCompile(`^create\s+table\s+(?:if\s+not\s+exists\s+)?([\w."]+)`)
+	createIndexPattern    = regexp.MustCompile(`^create\s+(?:unique\s+)?index\s+(concurrently\s+)?.*?\bon\s+(?:only\s+)?([\w."]+)`)
+	alterTablePattern     = regexp.MustCompile(`^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."]+)\s+(.*)$`)
+	addColumnPattern      = regexp.MustCompile(`^add\s+(?:column\s+)?(?:if\s+not\s+exists\s+)?([\w"]+)\s`)
+	alterTypePattern      = regexp.MustCompile(`^alter\s+(?:column\s+)?([\w"]+)\s+(?:set\s+data\s+)?type\s`)
+	setNotNullPattern     = regexp.MustCompile(`^alter\s+(?:column\s+)?([\w"]+)\s+set\s+not\s+null`)
+	dropColumnPattern     = regexp.MustCompile(`^drop\s+(?:column\s+)?(?:if\s+exists\s+)?([\w"]+)`)
+	addConstraintPattern  = regexp.MustCompile(`^add\s+(constraint|foreign|primary|unique|check|exclude)\b`)
+	dropConstraintPattern = regexp.MustCompile(`^drop\s+constraint\b`)
+)
+
+// LintMigrations checks the up migrations with a version greater than after, including the tenant schema migrations,
+// for operations that lock tables. Migrations are immutable once applied, so after is usually the version of the last
+// migration that was already reviewed. The migrations embedded in the binary are checked unless dir names a migrations directory on disk.
+// A finding is suppressed by a "-- lint:ignore <rule>" comment on the first line of its statement or on its own line
+// before the statement, or by a "-- lint:ignore-file <rule>" comment anywhere in the file
+func LintMigrations(dir string, after uint) ([]LintFinding, error) {
+	var findings []LintFinding
+
+	for _, subdir := range []string{"", "tenant"} {
+		src, err := newMigrationSource(dir, subdir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ms := src.(*migrationSource)
+
+		for v, ok := ms.migrations.First(); ok; v, ok = ms.migrations.Next(v) {
+			m, ok := ms.migrations.Up(v)
+
+			if !ok || v <= after {
+				continue
+			}
+
+			r, _, err := ms.open(m)
+
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := ioutil.ReadAll(r)
+			_ = r.Close()
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read migration %s: %w", m.Raw, err)
+			}
+
+			findings = append(findings, lintScript(path.Join(subdir, m.Raw), string(b))...)
+		}
+	}
+
+	return findings, nil
+}
+
+type lintStatement struct {
+	line int
+	sql  string
+}
+
+// lintScript checks a single migration script
+func lintScript(file string, script string) []LintFinding {
+	statements, ignoredLines, ignoredFile := splitStatements(script)
+	created := map[string]bool{}
+
+	var findings []LintFinding
+
+	report := func(line int, rule string, format string, args ...interface{}) {
+		if ignoredFile[rule] || ignoredLines[line][rule] {
+			return
+		}
+
+		findings = append(findings, LintFinding{
+			File:    file,
+			Line:    line,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, st := range statements {
+		if match := createTablePattern.FindStringSubmatch(st.sql); match != nil {
+			created[unquote(match[1])] = true
+			continue
+		}
+
+		if match := createIndexPattern.FindStringSubmatch(st.sql); match != nil {
+			table := unquote(match[2])
+
+			if match[1] == "" && !created[table] {
+				report(st.line, LintBlockingIndex, "index on %s is not created concurrently and blocks writes while it builds", table)
+			}
+
+			continue
+		}
+
+		match := alterTablePattern.FindStringSubmatch(st.sql)
+
+		if match == nil {
+			continue
+		}
+
+		table := unquote(match[1])
+
+		if created[table] {
+			continue
+		}
+
+		for _, clause := range splitClauses(match[2]) {
+			if m := addColumnPattern.FindStringSubmatch(clause); m != nil && !addConstraintPattern.MatchString(clause) {
+				if strings.Contains(clause, "not null") && !strings.Contains(clause, "default") {
+					report(st.line, LintNotNullWithoutDefault, "column %s.%s is added as not null without a default", table, unquote(m[1]))
+				}
+			}
+
+			if m := alterTypePattern.FindStringSubmatch(clause); m != nil {
+				report(st.line, LintColumnTypeChange, "changing the type of %s.%s can rewrite the table under an exclusive lock", table, unquote(m[1]))
+			}
+
+			if m := setNotNullPattern.FindStringSubmatch(clause); m != nil {
+				report(st.line, LintSetNotNull, "setting %s.%s not null scans the table under an exclusive lock", table, unquote(m[1]))
+			}
+
+			if m := dropColumnPattern.FindStringSubmatch(clause); m != nil && !dropConstraintPattern.MatchString(clause) {
+				column := unquote(m[1])
+
+				if modelReferencesColumn(table, column) {
+					report(st.line, LintDropReferencedColumn, "column %s.%s is still referenced by a model db tag", table, column)
+				}
+			}
+		}
+	}
+
+	return findings
+}
+
+// splitStatements splits a script into lower-cased statements with comments removed,
+// and collects the rules suppressed per line and per file
+func splitStatements(script string) ([]lintStatement, map[int]map[string]bool, map[string]bool) {
+	var statements []lintStatement
+	ignoredLines := map[int]map[string]bool{}
+	ignoredFile := map[string]bool{}
+
+	// rules suppressed by a comment on its own line apply to the next statement
+	pending := map[string]bool{}
+
+	var buf strings.Builder
+	start := 0
+
+	for i, line := range strings.Split(script, "\n") {
+		lineNo := i + 1
+		code := line
+
+		if idx := strings.Index(line, "--"); idx >= 0 {
+			code = line[:idx]
+		}
+
+		if m := lintIgnorePattern.FindStringSubmatch(line); m != nil {
+			for _, rule := range strings.Split(m[2], ",") {
+				rule = strings.TrimSpace(rule)
+
+				switch {
+				case m[1] != "":
+					ignoredFile[rule] = true
+				case strings.TrimSpace(code) == "":
+					pending[rule] = true
+				default:
+					if ignoredLines[lineNo] == nil {
+						ignoredLines[lineNo] = map[string]bool{}
+					}
+
+					ignoredLines[lineNo][rule] = true
+				}
+			}
+		}
+
+		for _, r := range code {
+			if start == 0 && r != ' ' && r != '\t' && r != '\r' && r != ';' {
+				start = lineNo
+
+				if ignoredLines[start] == nil {
+					ignoredLines[start] = map[string]bool{}
+				}
+
+				for rule := range pending {
+					ignoredLines[start][rule] = true
+				}
+
+				pending = map[string]bool{}
+			}
+
+			if r == ';' {
+				if start != 0 {
+					statements = append(statements, lintStatement{start, normalizeSql(buf.String())})
+				}
+
+				buf.Reset()
+				start = 0
+				continue
+			}
+
+			buf.WriteRune(r)
+		}
+
+		buf.WriteRune(' ')
+	}
+
+	if start != 0 {
+		statements = append(statements, lintStatement{start, normalizeSql(buf.String())})
+	}
+
+	return statements, ignoredLines, ignoredFile
+}
+
+// splitClauses splits the actions of an alter table statement on commas outside of parentheses
+func splitClauses(s string) []string {
+	var clauses []string
+	depth := 0
+	last := 0
+
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, strings.TrimSpace(s[last:i]))
+				last = i + 1
+			}
+		}
+	}
+
+	return append(clauses, strings.TrimSpace(s[last:]))
+}
+
+func normalizeSql(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func unquote(identifier string) string {
+	identifier = strings.ReplaceAll(identifier, `"`, "")
+
+	if idx := strings.LastIndex(identifier, "."); idx >= 0 {
+		identifier = identifier[idx+1:]
+	}
+
+	return identifier
+}
+
+func modelReferencesColumn(table string, column string) bool {
+	model, ok := tableModels[table]
+
+	if !ok {
+		return false
+	}
+
+	for _, c := range dbColumns(reflect.TypeOf(model)) {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dbColumns lists the db tags of a struct type, including those of embedded structs
+func dbColumns(t reflect.Type) []string {
+	var columns []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			columns = append(columns, dbColumns(f.Type)...)
+			continue
+		}
+
+		if tag := f.Tag.Get("db"); tag != "" && tag != "-" {
+			columns = append(columns, tag)
+		}
+	}
+
+	sort.Strings(columns)
+	return columns
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func rules(findings []LintFinding) []string {
+	var r []string
+
+	for _, f := range findings {
+		r = append(r, f.Rule)
+	}
+
+	return r
+}
+
+func TestLintScript(t *testing.T) {
+	findings := lintScript("a.up.sql", `
+create table widget (id uuid primary key, name varchar(255) not null);
+create index widget_name_idx on widget (name);
+
+create index tenant_name_idx on tenant (name);
+create index concurrently tenant_owner_idx on tenant (owner_id);
+
+alter table member
+    add column nickname varchar(255) not null,
+    add column rank int not null default 0,
+    alter column alias type text;
+
+ALTER TABLE "user" DROP COLUMN email;
+alter table "user" drop column legacy;
+alter table member alter column alias set not null;
+`)
+
+	expected := []LintFinding{
+		{"a.up.sql", 5, LintBlockingIndex, "index on tenant is not created concurrently and blocks writes while it builds"},
+		{"a.up.sql", 8, LintNotNullWithoutDefault, "column member.nickname is added as not null without a default"},
+		{"a.up.sql", 8, LintColumnTypeChange, "changing the type of member.alias can rewrite the table under an exclusive lock"},
+		{"a.up.sql", 13, LintDropReferencedColumn, "column user.email is still referenced by a model db tag"},
+		{"a.up.sql", 15, LintSetNotNull, "setting member.alias not null scans the table under an exclusive lock"},
+	}
+	assert.Equal(t, expected, findings)
+}
+
+func TestLintScriptSuppression(t *testing.T) {
+	findings := lintScript("a.up.sql", `
+-- lint:ignore blocking-index
+create index tenant_name_idx on tenant (name);
+create index tenant_owner_idx on tenant (owner_id); -- lint:ignore blocking-index
+create index tenant_parent_idx on tenant (parent_id);
+alter table member alter column alias type text;
+`)
+	assert.Equal(t, []string{LintBlockingIndex, LintColumnTypeChange}, rules(findings))
+
+	findings = lintScript("a.up.sql", `
+-- lint:ignore-file blocking-index, column-type-change
+create index tenant_parent_idx on tenant (parent_id);
+alter table member alter column alias type text;
+`)
+	assert.Empty(t, findings)
+}
+
+func TestLintMigrations(t *testing.T) {
+	findings, err := LintMigrations("testdata/lint", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []LintFinding{
+		{"1_widget.up.sql", 7, LintBlockingIndex, "index on tenant is not created concurrently and blocks writes while it builds"},
+		{"tenant/3_member_nickname.up.sql", 1, LintNotNullWithoutDefault, "column member.nickname is added as not null without a default"},
+	}, findings)
+
+	// only the migrations after a version are checked, including those of the tenant schemas
+	findings, err = LintMigrations("testdata/lint", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{LintNotNullWithoutDefault}, rules(findings))
+
+	findings, err = LintMigrations("testdata/lint", 3)
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+}
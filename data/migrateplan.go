@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"time"
+)
+
+// MigrationScript is the up SQL of a migration
+type MigrationScript struct {
+	MigrationFile
+	Sql string
+}
+
+// MigrationCheck is the outcome of applying a migration to a scratch schema
+type MigrationCheck struct {
+	MigrationFile
+	Pending bool
+	Err     error
+}
+
+// ReadUpMigrations reads the up SQL of the migrations with a version greater than after, in the order they would be applied.
+// The migrations embedded in the binary are read unless dir names a migrations directory on disk
+func ReadUpMigrations(dir string, after uint) ([]MigrationScript, error) {
+	return readUpMigrations(dir, "", after)
+}
+
+// ReadTenantUpMigrations reads the up SQL of the tenant schema migrations with a version greater than after,
+// in the order they would be applied. The migrations embedded in the binary are read unless dir names a migrations directory on disk
+func ReadTenantUpMigrations(dir string, after uint) ([]MigrationScript, error) {
+	return readUpMigrations(dir, "tenant", after)
+}
+
+// readUpMigrations reads the up SQL of the migrations in a subdirectory of the migrations folder
+// with a version greater than after, in the order they would be applied
+func readUpMigrations(dir string, subdir string, after uint) ([]MigrationScript, error) {
+	src, err := newMigrationSource(dir, subdir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ms := src.(*migrationSource)
+
+	var scripts []MigrationScript
+
+	for v, ok := ms.migrations.First(); ok; v, ok = ms.migrations.Next(v) {
+		m, ok := ms.migrations.Up(v)
+
+		if !ok || v <= after {
+			continue
+		}
+
+		f := MigrationFile{Version: v, Identifier: m.Identifier}
+
+		r, _, err := src.ReadUp(f.Version)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %d: %w", f.Version, err)
+		}
+
+		b, err := ioutil.ReadAll(r)
+		_ = r.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %d: %w", f.Version, err)
+		}
+
+		scripts = append(scripts, MigrationScript{MigrationFile: f, Sql: string(b)})
+	}
+
+	return scripts, nil
+}
+
+// VerifyMigrations replays every migration into a scratch schema inside a transaction that is always rolled back.
+// Migrations with a version greater than applied are reported as pending.
+// Replaying stops at the first migration that fails
+func VerifyMigrations(d *sql.DB, dir string, applied uint) ([]MigrationCheck, error) {
+	scripts, err := ReadUpMigrations(dir, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyMigrations(d, scripts, applied)
+}
+
+// VerifyTenantMigrations replays every tenant schema migration into a scratch schema inside a transaction that is always rolled back.
+// The tenant tables reference the live public schema, so the public migrations should be applied or verified first.
+// Migrations with a version greater than applied are reported as pending.
+// Replaying stops at the first migration that fails
+func VerifyTenantMigrations(d *sql.DB, dir string, applied uint) ([]MigrationCheck, error) {
+	scripts, err := ReadTenantUpMigrations(dir, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyMigrations(d, scripts, applied)
+}
+
+func verifyMigrations(d *sql.DB, scripts []MigrationScript, applied uint) ([]MigrationCheck, error) {
+	var checks []MigrationCheck
+
+	err := withScratchSchema(d, func(tx *sql.Tx, schema string) error {
+		for _, s := range scripts {
+			_, err := tx.Exec(s.Sql)
+
+			checks = append(checks, MigrationCheck{
+				MigrationFile: s.MigrationFile,
+				Pending:       s.Version > applied,
+				Err:           err,
+			})
+
+			if err != nil {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// withScratchSchema calls fn inside a transaction whose search_path points to a new, empty schema.
+// The transaction is always rolled back, so the schema and everything created in it are discarded
+func withScratchSchema(d *sql.DB, fn func(tx *sql.Tx, schema string) error) error {
+	tx, err := d.BeginTx(context.Background(), nil)
+
+	if err != nil {
+		return fmt.Errorf("failed to begin scratch transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	schema := fmt.Sprintf("migration_scratch_%d", time.Now().UnixNano())
+
+	if _, err := tx.Exec("create schema " + pq.QuoteIdentifier(schema)); err != nil {
+		return fmt.Errorf("failed to create scratch schema: %w", err)
+	}
+
+	if _, err := tx.Exec("set local search_path to " + pq.QuoteIdentifier(schema)); err != nil {
+		return fmt.Errorf("failed to switch to scratch schema: %w", err)
+	}
+
+	return fn(tx, schema)
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4/source"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+//go:embed migrations/*.sql migrations/tenant/*.sql
+var embeddedMigrations embed.FS
+
+// migrationSource is a migrate source driver that reads migrations from a file system,
+// either the migrations embedded in the binary or a directory on disk
+type migrationSource struct {
+	fsys       fs.FS
+	dir        string
+	migrations *source.Migrations
+}
+
+// newMigrationSource returns a source driver for the migrations in a subdirectory of the migrations folder.
+// If dir is empty the embedded migrations are used, otherwise they are read from dir on disk
+func newMigrationSource(dir string, subdir string) (source.Driver, error) {
+	var fsys fs.FS
+	var root string
+
+	if dir == "" {
+		fsys = embeddedMigrations
+		root = path.Join("migrations", subdir)
+	} else {
+		fsys = os.DirFS(dir)
+		root = subdir
+	}
+
+	if root == "" {
+		root = "."
+	}
+
+	entries, err := fs.ReadDir(fsys, root)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	ms := &migrationSource{
+		fsys:       fsys,
+		dir:        root,
+		migrations: source.NewMigrations(),
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		m, err := source.DefaultParse(e.Name())
+
+		if err != nil {
+			continue // ignore files that are not migrations
+		}
+
+		if !ms.migrations.Append(m) {
+			return nil, fmt.Errorf("unable to parse migration file %s", e.Name())
+		}
+	}
+
+	return ms, nil
+}
+
+func (ms *migrationSource) Open(url string) (source.Driver, error) {
+	return nil, errors.New("migration source must be created with newMigrationSource")
+}
+
+func (ms *migrationSource) Close() error {
+	return nil
+}
+
+func (ms *migrationSource) First() (uint, error) {
+	if v, ok := ms.migrations.First(); ok {
+		return v, nil
+	}
+
+	return 0, &os.PathError{Op: "first", Path: ms.dir, Err: os.ErrNotExist}
+}
+
+func (ms *migrationSource) Prev(version uint) (uint, error) {
+	if v, ok := ms.migrations.Prev(version); ok {
+		return v, nil
+	}
+
+	return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: ms.dir, Err: os.ErrNotExist}
+}
+
+func (ms *migrationSource) Next(version uint) (uint, error) {
+	if v, ok := ms.migrations.Next(version); ok {
+		return v, nil
+	}
+
+	return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: ms.dir, Err: os.ErrNotExist}
+}
+
+func (ms *migrationSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := ms.migrations.Up(version); ok {
+		return ms.open(m)
+	}
+
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: ms.dir, Err: os.ErrNotExist}
+}
+
+func (ms *migrationSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := ms.migrations.Down(version); ok {
+		return ms.open(m)
+	}
+
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: ms.dir, Err: os.ErrNotExist}
+}
+
+func (ms *migrationSource) open(m *source.Migration) (io.ReadCloser, string, error) {
+	f, err := ms.fsys.Open(path.Join(ms.dir, m.Raw))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return f, m.Identifier, nil
+}
+
+// MigrationFile describes a migration available in a migration source
+type MigrationFile struct {
+	Version    uint
+	Identifier string
+}
+
+// ListMigrations lists the migrations in ascending version order.
+// The migrations embedded in the binary are listed unless dir names a migrations directory on disk
+func ListMigrations(dir string) ([]MigrationFile, error) {
+	return listMigrations(dir, "")
+}
+
+// ListTenantMigrations lists the migrations of tenant schemas in ascending version order.
+// The migrations embedded in the binary are listed unless dir names a migrations directory on disk
+func ListTenantMigrations(dir string) ([]MigrationFile, error) {
+	return listMigrations(dir, "tenant")
+}
+
+func listMigrations(dir string, subdir string) ([]MigrationFile, error) {
+	src, err := newMigrationSource(dir, subdir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ms := src.(*migrationSource)
+
+	var files []MigrationFile
+
+	v, ok := ms.migrations.First()
+
+	for ok {
+		m, hasUp := ms.migrations.Up(v)
+
+		if !hasUp {
+			m, _ = ms.migrations.Down(v)
+		}
+
+		files = append(files, MigrationFile{Version: v, Identifier: m.Identifier})
+		v, ok = ms.migrations.Next(v)
+	}
+
+	return files, nil
+}
+
+// LatestMigrationVersion returns the version of the last migration, which is the schema version the binary expects.
+// The migrations embedded in the binary are used unless dir names a migrations directory on disk
+func LatestMigrationVersion(dir string) (uint, error) {
+	return latestVersion(ListMigrations(dir))
+}
+
+// LatestTenantMigrationVersion returns the version of the latest tenant migration, see LatestMigrationVersion
+func LatestTenantMigrationVersion(dir string) (uint, error) {
+	return latestVersion(ListTenantMigrations(dir))
+}
+
+func latestVersion(files []MigrationFile, err error) (uint, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	if len(files) == 0 {
+		return 0, errors.New("no migrations found")
+	}
+
+	return files[len(files)-1].Version, nil
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func versions(t *testing.T, src source.Driver) []uint {
+	var vs []uint
+
+	v, err := src.First()
+
+	for err == nil {
+		vs = append(vs, v)
+		v, err = src.Next(v)
+	}
+
+	assert.True(t, os.IsNotExist(err))
+	return vs
+}
+
+func TestMigrationSource(t *testing.T) {
+	for _, subdir := range []string{"", "tenant"} {
+		embedded, err := newMigrationSource("", subdir)
+		assert.Nil(t, err)
+
+		disk, err := newMigrationSource("./migrations", subdir)
+		assert.Nil(t, err)
+
+		embeddedVersions := versions(t, embedded)
+		assert.NotEmpty(t, embeddedVersions)
+		assert.Equal(t, versions(t, disk), embeddedVersions)
+
+		r, identifier, err := embedded.ReadUp(embeddedVersions[0])
+		assert.Nil(t, err)
+		assert.Equal(t, "init", identifier)
+		_ = r.Close()
+	}
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	files, err := ListMigrations("")
+	assert.Nil(t, err)
+
+	v, err := LatestMigrationVersion("")
+	assert.Nil(t, err)
+	assert.Equal(t, files[len(files)-1].Version, v)
+
+	dir, err := ioutil.TempDir("", "migrations")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LatestMigrationVersion(dir)
+	assert.NotNil(t, err)
+}
+
+func TestReadTenantUpMigrations(t *testing.T) {
+	files, err := ListTenantMigrations("")
+	assert.Nil(t, err)
+
+	scripts, err := ReadTenantUpMigrations("", 0)
+	assert.Nil(t, err)
+	assert.Len(t, scripts, len(files))
+
+	for i, s := range scripts {
+		assert.Equal(t, files[i], s.MigrationFile)
+		assert.NotEmpty(t, s.Sql)
+	}
+
+	pending, err := ReadTenantUpMigrations("", files[0].Version)
+	assert.Nil(t, err)
+	assert.Equal(t, scripts[1:], pending)
+
+	// the public migrations do not include the tenant migrations
+	public, err := ReadUpMigrations("", 0)
+	assert.Nil(t, err)
+
+	for _, s := range public {
+		for _, tenant := range scripts {
+			assert.NotEqual(t, tenant.Sql, s.Sql)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+drop index if exists tenant_parent_id_idx;
+alter table tenant drop column if exists parent_id;
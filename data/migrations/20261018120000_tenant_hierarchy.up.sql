@@ -0,0 +1,7 @@
+alter table tenant
+    add column parent_id uuid default null,
+    add foreign key (parent_id) references tenant (id);
+
+-- tenant is small enough to index without concurrently
+-- lint:ignore blocking-index
+create index tenant_parent_id_idx on tenant (parent_id);
@@ -0,0 +1,2 @@
+drop table if exists api_key;
+drop table if exists service_account;
@@ -0,0 +1,24 @@
+create table service_account
+(
+    id         uuid primary key,
+    tenant_id  uuid         not null,
+    name       varchar(255) not null,
+    roles      text[]       not null default '{}',
+    created_at timestamp             default (now() at time zone 'utc'),
+
+    unique (tenant_id, name),
+    foreign key (tenant_id) references tenant (id) on delete cascade
+);
+
+create table api_key
+(
+    id                 uuid primary key,
+    service_account_id uuid        not null,
+    prefix             varchar(32) not null unique,
+    secret_hash        bytea       not null,
+    created_at         timestamp default (now() at time zone 'utc'),
+    last_used_at       timestamp default null,
+    revoked_at         timestamp default null,
+
+    foreign key (service_account_id) references service_account (id) on delete cascade
+);
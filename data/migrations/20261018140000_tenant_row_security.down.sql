@@ -0,0 +1,10 @@
+drop policy if exists service_account_tenant_isolation on service_account;
+alter table service_account disable row level security;
+
+drop policy if exists joinrequest_tenant_isolation on joinrequest;
+alter table joinrequest disable row level security;
+
+drop policy if exists member_tenant_isolation on member;
+alter table member disable row level security;
+
+drop function if exists current_tenant_id();
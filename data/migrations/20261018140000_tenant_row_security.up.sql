@@ -0,0 +1,25 @@
+-- rows of tenant-scoped tables are only visible to transactions whose app.tenant_id setting matches their tenant.
+-- Transactions that do not set app.tenant_id are unrestricted so that cross-tenant operations remain possible.
+-- Superusers and roles with bypassrls are never subject to these policies, the service should connect as a regular role.
+create function current_tenant_id() returns uuid as
+$$
+select nullif(current_setting('app.tenant_id', true), '')::uuid
+$$ language sql stable;
+
+alter table member enable row level security;
+alter table member force row level security;
+create policy member_tenant_isolation on member
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
+
+alter table joinrequest enable row level security;
+alter table joinrequest force row level security;
+create policy joinrequest_tenant_isolation on joinrequest
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
+
+alter table service_account enable row level security;
+alter table service_account force row level security;
+create policy service_account_tenant_isolation on service_account
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
@@ -0,0 +1,6 @@
+alter table api_key drop column if exists version;
+alter table service_account drop column if exists version;
+alter table member drop column if exists version;
+alter table joinrequest drop column if exists version;
+alter table tenant drop column if exists version;
+alter table "user" drop column if exists version;
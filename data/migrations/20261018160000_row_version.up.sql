@@ -0,0 +1,7 @@
+-- version is incremented by every update, for optimistic concurrency control
+alter table "user" add column version integer not null default 1;
+alter table tenant add column version integer not null default 1;
+alter table joinrequest add column version integer not null default 1;
+alter table member add column version integer not null default 1;
+alter table service_account add column version integer not null default 1;
+alter table api_key add column version integer not null default 1;
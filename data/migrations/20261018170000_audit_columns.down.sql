@@ -0,0 +1,32 @@
+alter table api_key
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table service_account
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table joinrequest
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table member
+    drop column if exists created_at,
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table tenant
+    drop column if exists created_at,
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table "user"
+    drop column if exists created_at,
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
@@ -0,0 +1,34 @@
+-- when and by whom each row was created and last updated.
+-- created_by and updated_by are user ids, but are not foreign keys so that they can outlive the users they name
+alter table "user"
+    add column created_at timestamp not null default (now() at time zone 'utc'),
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table tenant
+    add column created_at timestamp not null default (now() at time zone 'utc'),
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table member
+    add column created_at timestamp not null default (now() at time zone 'utc'),
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table joinrequest
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table service_account
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table api_key
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
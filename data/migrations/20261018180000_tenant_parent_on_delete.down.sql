@@ -0,0 +1,3 @@
+alter table tenant
+    drop constraint tenant_parent_id_fkey,
+    add constraint tenant_parent_id_fkey foreign key (parent_id) references tenant (id);
@@ -0,0 +1,4 @@
+-- deleting a tenant detaches its child tenants instead of failing
+alter table tenant
+    drop constraint tenant_parent_id_fkey,
+    add constraint tenant_parent_id_fkey foreign key (parent_id) references tenant (id) on delete set null;
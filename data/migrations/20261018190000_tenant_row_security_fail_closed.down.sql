@@ -0,0 +1,17 @@
+drop policy if exists service_account_rls_bypass on service_account;
+drop policy if exists service_account_tenant_isolation on service_account;
+create policy service_account_tenant_isolation on service_account
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
+
+drop policy if exists joinrequest_rls_bypass on joinrequest;
+drop policy if exists joinrequest_tenant_isolation on joinrequest;
+create policy joinrequest_tenant_isolation on joinrequest
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
+
+drop policy if exists member_rls_bypass on member;
+drop policy if exists member_tenant_isolation on member;
+create policy member_tenant_isolation on member
+    using (current_tenant_id() is null or tenant_id = current_tenant_id())
+    with check (current_tenant_id() is null or tenant_id = current_tenant_id());
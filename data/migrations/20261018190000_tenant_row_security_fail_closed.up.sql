@@ -0,0 +1,35 @@
+-- rows of tenant-scoped tables are no longer visible to transactions that do not set app.tenant_id.
+-- Cross-tenant operations, e.g. those of the admin commands, must connect as a role that is granted xtenancy_rls_bypass.
+-- The role that serves tenants must not be granted it. Creating the role requires the createrole privilege
+do
+$$
+    begin
+        if not exists(select from pg_roles where rolname = 'xtenancy_rls_bypass') then
+            create role xtenancy_rls_bypass nologin;
+        end if;
+    end
+$$;
+
+drop policy member_tenant_isolation on member;
+create policy member_tenant_isolation on member
+    using (tenant_id = current_tenant_id())
+    with check (tenant_id = current_tenant_id());
+create policy member_rls_bypass on member to xtenancy_rls_bypass
+    using (true)
+    with check (true);
+
+drop policy joinrequest_tenant_isolation on joinrequest;
+create policy joinrequest_tenant_isolation on joinrequest
+    using (tenant_id = current_tenant_id())
+    with check (tenant_id = current_tenant_id());
+create policy joinrequest_rls_bypass on joinrequest to xtenancy_rls_bypass
+    using (true)
+    with check (true);
+
+drop policy service_account_tenant_isolation on service_account;
+create policy service_account_tenant_isolation on service_account
+    using (tenant_id = current_tenant_id())
+    with check (tenant_id = current_tenant_id());
+create policy service_account_rls_bypass on service_account to xtenancy_rls_bypass
+    using (true)
+    with check (true);
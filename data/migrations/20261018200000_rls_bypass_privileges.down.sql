@@ -0,0 +1,3 @@
+alter default privileges in schema public revoke select, insert, update, delete on tables from xtenancy_rls_bypass;
+revoke select, insert, update, delete on all tables in schema public from xtenancy_rls_bypass;
+revoke usage on schema public from xtenancy_rls_bypass;
@@ -0,0 +1,5 @@
+-- the bypass role can be assumed as the admin role of the admin commands (DB_ADMIN_ROLE), which switch to it with SET ROLE.
+-- It gets the privileges on the tables of the public schema, including those that later migrations create
+grant usage on schema public to xtenancy_rls_bypass;
+grant select, insert, update, delete on all tables in schema public to xtenancy_rls_bypass;
+alter default privileges in schema public grant select, insert, update, delete on tables to xtenancy_rls_bypass;
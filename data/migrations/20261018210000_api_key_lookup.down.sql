@@ -0,0 +1 @@
+drop function api_key_by_prefix(varchar);
@@ -0,0 +1,20 @@
+-- api keys are presented before their tenant is known, so the role that serves tenants cannot see their service account.
+-- The lookup runs with the privileges of the migrating role, which row level security does not restrict
+-- (a superuser, a role with bypassrls or one granted xtenancy_rls_bypass), and only ever returns the key with the prefix
+create function api_key_by_prefix(key_prefix varchar)
+    returns table
+            (
+                id                 uuid,
+                service_account_id uuid,
+                secret_hash        bytea,
+                tenant_id          uuid,
+                roles              text[]
+            )
+as
+$$
+select api_key.id, api_key.service_account_id, api_key.secret_hash, service_account.tenant_id, service_account.roles
+from api_key
+         join service_account on service_account.id = api_key.service_account_id
+where api_key.prefix = key_prefix
+  and api_key.revoked_at is null
+$$ language sql stable security definer set search_path = public;
@@ -0,0 +1 @@
+drop function effective_memberships(uuid, uuid);
@@ -0,0 +1,40 @@
+-- a user's membership of a tenant includes the admin memberships of its ancestors, which belong to other tenants
+-- and are not visible in the tenant's scope. Like api_key_by_prefix, the lookup runs with the privileges of the migrating role
+-- and only returns the active memberships of the user that confer rights on the tenant
+create function effective_memberships(target_tenant_id uuid, target_user_id uuid)
+    returns table
+            (
+                id          uuid,
+                tenant_id   uuid,
+                user_id     uuid,
+                alias       varchar,
+                is_admin    boolean,
+                is_inactive boolean,
+                depth       int
+            )
+as
+$$
+with recursive ancestry as (
+    select tenant.id, tenant.parent_id, 0 as depth, array [tenant.id] as path
+    from tenant
+    where tenant.id = target_tenant_id
+    union all
+    select parent.id, parent.parent_id, ancestry.depth + 1, ancestry.path || parent.id
+    from tenant parent
+             join ancestry on parent.id = ancestry.parent_id
+    where not parent.id = any (ancestry.path)
+)
+select member.id,
+       member.tenant_id,
+       member.user_id,
+       member.alias,
+       coalesce(member.is_admin, false),
+       coalesce(member.is_inactive, false),
+       ancestry.depth
+from member
+         join ancestry on member.tenant_id = ancestry.id
+where member.user_id = target_user_id
+  and member.is_inactive is not true
+  and (ancestry.depth = 0 or member.is_admin)
+order by ancestry.depth
+$$ language sql stable security definer set search_path = public;
@@ -0,0 +1 @@
+drop function count_pending_joinrequests(timestamp);
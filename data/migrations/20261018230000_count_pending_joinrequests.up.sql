@@ -0,0 +1,29 @@
+-- the pending joinrequests of all tenants are counted for the metrics in one call rather than one transaction per tenant.
+-- Like api_key_by_prefix, the count runs with the privileges of the migrating role, and it only returns a number.
+-- With schema isolation, the joinrequests of the tenant schemas are counted too
+create function count_pending_joinrequests(at timestamp) returns bigint as
+$$
+declare
+    total         bigint;
+    schema_total  bigint;
+    tenant_schema name;
+begin
+    select count(*)
+    into total
+    from joinrequest
+    where is_accepted is null
+      and (expires_at is null or expires_at > at);
+
+    for tenant_schema in select nspname from pg_namespace where nspname like 'tenant\_%'
+        loop
+            execute format('select count(*) from %I.joinrequest where is_accepted is null and (expires_at is null or expires_at > $1)',
+                           tenant_schema)
+                into schema_total
+                using at;
+
+            total := total + schema_total;
+        end loop;
+
+    return total;
+end
+$$ language plpgsql stable security definer set search_path = public;
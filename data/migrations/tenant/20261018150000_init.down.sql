@@ -0,0 +1,2 @@
+drop table if exists joinrequest;
+drop table if exists member;
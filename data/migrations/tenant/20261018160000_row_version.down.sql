@@ -0,0 +1,2 @@
+alter table member drop column if exists version;
+alter table joinrequest drop column if exists version;
@@ -0,0 +1,2 @@
+alter table joinrequest add column version integer not null default 1;
+alter table member add column version integer not null default 1;
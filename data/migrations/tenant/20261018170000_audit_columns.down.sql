@@ -0,0 +1,10 @@
+alter table joinrequest
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
+
+alter table member
+    drop column if exists created_at,
+    drop column if exists updated_at,
+    drop column if exists created_by,
+    drop column if exists updated_by;
@@ -0,0 +1,10 @@
+alter table member
+    add column created_at timestamp not null default (now() at time zone 'utc'),
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
+
+alter table joinrequest
+    add column updated_at timestamp not null default (now() at time zone 'utc'),
+    add column created_by uuid default null,
+    add column updated_by uuid default null;
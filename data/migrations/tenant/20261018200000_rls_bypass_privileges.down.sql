@@ -0,0 +1,7 @@
+do
+$$
+    begin
+        execute format('revoke select, insert, update, delete on all tables in schema %I from xtenancy_rls_bypass', current_schema());
+        execute format('revoke usage on schema %I from xtenancy_rls_bypass', current_schema());
+    end
+$$;
@@ -0,0 +1,8 @@
+-- the admin role of the admin commands reads and writes the tables of tenant schemas too
+do
+$$
+    begin
+        execute format('grant usage on schema %I to xtenancy_rls_bypass', current_schema());
+        execute format('grant select, insert, update, delete on all tables in schema %I to xtenancy_rls_bypass', current_schema());
+    end
+$$;
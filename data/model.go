@@ -2,41 +2,108 @@ package data
 
 import (
 	"github.com/gocraft/dbr/v2"
+	"github.com/lib/pq"
 	"time"
 )
 
+// AnyVersion is passed as the expected version of an update to skip the version check
+const AnyVersion = 0
+
 type User struct {
-	Id        string `db:"id" json:"id" validate:"uuid,required"`
-	AuthId    string `db:"auth_id" json:"authId" validate:"uuid,required"`
-	Email     string `db:"email" validate:"email,required"`
-	FirstName string `db:"first_name" json:"firstName,required"`
-	LastName  string `db:"last_name" json:"lastName,required"`
+	Id        string         `db:"id" json:"id" validate:"uuid,required"`
+	AuthId    string         `db:"auth_id" json:"authId" validate:"uuid,required"`
+	Email     string         `db:"email" json:"email" validate:"email,required"`
+	FirstName string         `db:"first_name" json:"firstName,required"`
+	LastName  string         `db:"last_name" json:"lastName,required"`
+	Version   int            `db:"version" json:"version"`
+	CreatedAt time.Time      `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy dbr.NullString `db:"updated_by" json:"updatedBy"`
 }
 
 type Tenant struct {
-	Id   string `db:"id" json:"id" validate:"uuid,required"`
-	Name string `db:"name" json:"required"`
-	OwnerId string `db:"owner_id" json:"ownerId" validate:"uuid,required"`
+	Id        string         `db:"id" json:"id" validate:"uuid,required"`
+	Name      string         `db:"name" json:"name" validate:"required"`
+	OwnerId   string         `db:"owner_id" json:"ownerId" validate:"uuid,required"`
+	ParentId  dbr.NullString `db:"parent_id" json:"parentId" validate:"omitempty,uuid"`
+	Version   int            `db:"version" json:"version"`
+	CreatedAt time.Time      `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy dbr.NullString `db:"updated_by" json:"updatedBy"`
+}
+
+// EffectiveMember is a user's resolved membership of a tenant, taking rights inherited from ancestor tenants into account
+type EffectiveMember struct {
+	TenantId string         `json:"tenantId"`
+	UserId   string         `json:"userId"`
+	MemberId dbr.NullString `json:"memberId"`
+	IsAdmin  bool           `json:"isAdmin"`
+	AdminVia dbr.NullString `json:"adminVia"`
 }
 
 type Joinrequest struct {
 	Id         string         `db:"id" json:"id" validate:"uuid,required"`
-	TenantId   string         `db:"tenant_id" json:"id" validate:"uuid,required"`
-	UserId     dbr.NullString `db:"user_id" json:"userId" validate:"uuid"`
-	AnonEmail  dbr.NullString `db:"anon_email" json:"anonEmail" validate:"email"`
+	TenantId   string         `db:"tenant_id" json:"tenantId" validate:"uuid,required"`
+	UserId     dbr.NullString `db:"user_id" json:"userId" validate:"omitempty,uuid"`
+	AnonEmail  dbr.NullString `db:"anon_email" json:"anonEmail" validate:"omitempty,email"`
 	IsAccepted dbr.NullBool   `db:"is_accepted" json:"isAccepted"`
 	IsFromUser dbr.NullBool   `db:"is_from_user" json:"isFromUser"`
 	CreatedAt  time.Time      `db:"created_at" json:"createdAt,required"`
 	ExpiresAt  dbr.NullTime   `db:"expires_at" json:"expiresAt"`
+	Version    int            `db:"version" json:"version"`
+	UpdatedAt  time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy  dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy  dbr.NullString `db:"updated_by" json:"updatedBy"`
 }
 
 type Member struct {
 	Id         string         `db:"id" json:"id" validate:"uuid,required"`
-	TenantId   string         `db:"tenant_id" json:"id" validate:"uuid,required"`
+	TenantId   string         `db:"tenant_id" json:"tenantId" validate:"uuid,required"`
 	UserId     string         `db:"user_id" json:"userId" validate:"uuid,required"`
 	Alias      dbr.NullString `db:"alias" json:"alias"`
 	IsAdmin    bool           `db:"is_admin" json:"isAdmin,required"`
 	IsInactive bool           `db:"is_inactive" json:"isInactive"`
+	Version    int            `db:"version" json:"version"`
+	CreatedAt  time.Time      `db:"created_at" json:"createdAt"`
+	UpdatedAt  time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy  dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy  dbr.NullString `db:"updated_by" json:"updatedBy"`
+}
+
+type ServiceAccount struct {
+	Id        string         `db:"id" json:"id" validate:"uuid,required"`
+	TenantId  string         `db:"tenant_id" json:"tenantId" validate:"uuid,required"`
+	Name      string         `db:"name" json:"name" validate:"required"`
+	Roles     pq.StringArray `db:"roles" json:"roles"`
+	CreatedAt time.Time      `db:"created_at" json:"createdAt"`
+	Version   int            `db:"version" json:"version"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy dbr.NullString `db:"updated_by" json:"updatedBy"`
+}
+
+type ApiKey struct {
+	Id               string         `db:"id" json:"id" validate:"uuid,required"`
+	ServiceAccountId string         `db:"service_account_id" json:"serviceAccountId" validate:"uuid,required"`
+	Prefix           string         `db:"prefix" json:"prefix" validate:"required"`
+	SecretHash       []byte         `db:"secret_hash" json:"-" validate:"required"`
+	CreatedAt        time.Time      `db:"created_at" json:"createdAt"`
+	LastUsedAt       dbr.NullTime   `db:"last_used_at" json:"lastUsedAt"`
+	RevokedAt        dbr.NullTime   `db:"revoked_at" json:"revokedAt"`
+	Version          int            `db:"version" json:"version"`
+	UpdatedAt        time.Time      `db:"updated_at" json:"updatedAt"`
+	CreatedBy        dbr.NullString `db:"created_by" json:"createdBy"`
+	UpdatedBy        dbr.NullString `db:"updated_by" json:"updatedBy"`
+}
+
+// ApiKeyIdentity is the tenant and role set that a presented api key resolves to
+type ApiKeyIdentity struct {
+	ApiKeyId         string   `json:"apiKeyId"`
+	ServiceAccountId string   `json:"serviceAccountId"`
+	TenantId         string   `json:"tenantId"`
+	Roles            []string `json:"roles"`
 }
 
 func (j *Joinrequest) comparable() *Joinrequest {
@@ -49,3 +116,24 @@ func (j *Joinrequest) comparable() *Joinrequest {
 		ExpiresAt:  j.ExpiresAt,
 	}
 }
+
+func (u *User) comparable() *User {
+	return &User{
+		Id:        u.Id,
+		AuthId:    u.AuthId,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Version:   u.Version,
+	}
+}
+
+func (t *Tenant) comparable() *Tenant {
+	return &Tenant{
+		Id:       t.Id,
+		Name:     t.Name,
+		OwnerId:  t.OwnerId,
+		ParentId: t.ParentId,
+		Version:  t.Version,
+	}
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaDrift lists the differences between a live schema and the schema produced by replaying the migrations.
+// Each entry describes a table, column, constraint, index, row security setting, policy or function
+type SchemaDrift struct {
+	// Missing entries are produced by the migrations but absent from the live schema
+	Missing []string
+	// Unexpected entries exist in the live schema but are not produced by the migrations
+	Unexpected []string
+}
+
+// HasDrift reports whether the live schema differs from the migrations
+func (sd *SchemaDrift) HasDrift() bool {
+	return len(sd.Missing) > 0 || len(sd.Unexpected) > 0
+}
+
+// DetectSchemaDrift compares a live schema to the schema produced by replaying the migrations up to and including
+// the applied version into a scratch schema. The scratch schema is discarded afterwards
+func DetectSchemaDrift(d *sql.DB, dir string, schema string, applied uint) (*SchemaDrift, error) {
+	scripts, err := ReadUpMigrations(dir, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &SchemaDrift{}
+
+	err = withScratchSchema(d, func(tx *sql.Tx, scratch string) error {
+		if _, err := tx.Exec("set local search_path to " + pq.QuoteIdentifier(schema)); err != nil {
+			return err
+		}
+
+		live, err := snapshotSchema(tx, schema)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("set local search_path to " + pq.QuoteIdentifier(scratch)); err != nil {
+			return err
+		}
+
+		for _, s := range scripts {
+			if s.Version > applied {
+				break
+			}
+
+			if _, err := tx.Exec(s.Sql); err != nil {
+				return fmt.Errorf("failed to replay migration %d: %w", s.Version, err)
+			}
+		}
+
+		expected, err := snapshotSchema(tx, scratch)
+
+		if err != nil {
+			return err
+		}
+
+		drift.Missing = difference(expected, live)
+		drift.Unexpected = difference(live, expected)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return drift, nil
+}
+
+// snapshotSchema describes the tables, columns, constraints, indexes, row security settings, policies and functions
+// of a schema as a set of lines.
+// References to the schema itself are removed so that snapshots of different schemas are comparable
+func snapshotSchema(tx *sql.Tx, schema string) (map[string]bool, error) {
+	snapshot := map[string]bool{}
+
+	queries := []string{
+		`select 'table ' || table_name
+		from information_schema.tables
+		where table_schema = $1 and table_type = 'BASE TABLE' and table_name <> 'schema_migrations'`,
+
+		`select 'column ' || table_name || '.' || column_name || ' ' || data_type ||
+			coalesce('(' || character_maximum_length || ')', '') ||
+			case when is_nullable = 'NO' then ' not null' else '' end ||
+			coalesce(' default ' || column_default, '')
+		from information_schema.columns
+		where table_schema = $1 and table_name <> 'schema_migrations'`,
+
+		`select 'constraint ' || c.relname || '.' || con.conname || ' ' || pg_get_constraintdef(con.oid)
+		from pg_constraint con
+		join pg_class c on c.oid = con.conrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where n.nspname = $1 and c.relname <> 'schema_migrations'`,
+
+		`select 'index ' || tablename || '.' || indexname || ' ' || indexdef
+		from pg_indexes
+		where schemaname = $1 and tablename <> 'schema_migrations'`,
+
+		`select 'row security ' || c.relname ||
+			case when c.relforcerowsecurity then ' forced' else '' end
+		from pg_class c
+		join pg_namespace n on n.oid = c.relnamespace
+		where n.nspname = $1 and c.relrowsecurity`,
+
+		`select 'policy ' || tablename || '.' || policyname || ' ' || permissive || ' ' || cmd ||
+			' to ' || array_to_string(roles, ',') ||
+			coalesce(' using ' || qual, '') ||
+			coalesce(' with check ' || with_check, '')
+		from pg_policies
+		where schemaname = $1`,
+
+		`select 'function ' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')' ||
+			' returns ' || pg_get_function_result(p.oid) || ' language ' || l.lanname ||
+			case p.provolatile when 'i' then ' immutable' when 's' then ' stable' else ' volatile' end ||
+			case when p.prosecdef then ' security definer' else '' end ||
+			' as ' || regexp_replace(trim(p.prosrc), '\s+', ' ', 'g')
+		from pg_proc p
+		join pg_namespace n on n.oid = p.pronamespace
+		join pg_language l on l.oid = p.prolang
+		where n.nspname = $1 and p.prokind in ('f', 'p')`,
+	}
+
+	for _, q := range queries {
+		rows, err := tx.Query(q, schema)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to introspect schema %s: %w", schema, err)
+		}
+
+		for rows.Next() {
+			var line string
+
+			if err := rows.Scan(&line); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to introspect schema %s: %w", schema, err)
+			}
+
+			snapshot[unqualify(line, schema)] = true
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to introspect schema %s: %w", schema, err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// unqualify removes references to a schema from a line, e.g. "public.member" becomes "member".
+// Only whole identifiers outside of string literals are removed, so "xpublic.member" and 'public.x' are kept
+func unqualify(s string, schema string) string {
+	pattern := regexp.MustCompile(`(^|[^\w$".])(` + regexp.QuoteMeta(pq.QuoteIdentifier(schema)) + `|` + regexp.QuoteMeta(schema) + `)\.`)
+	parts := strings.Split(s, "'")
+
+	// the even parts are outside of string literals, an escaped quote '' splits a literal around an empty even part
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = pattern.ReplaceAllString(parts[i], "$1")
+	}
+
+	return strings.Join(parts, "'")
+}
+
+// difference returns the sorted entries of a that are not in b
+func difference(a map[string]bool, b map[string]bool) []string {
+	var diff []string
+
+	for k := range a {
+		if !b[k] {
+			diff = append(diff, k)
+		}
+	}
+
+	sort.Strings(diff)
+	return diff
+}
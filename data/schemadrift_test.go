@@ -0,0 +1,31 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUnqualify(t *testing.T) {
+	for line, expected := range map[string]string{
+		"index member.member_pkey CREATE UNIQUE INDEX member_pkey ON public.member USING btree (id)": "index member.member_pkey CREATE UNIQUE INDEX member_pkey ON member USING btree (id)",
+		`constraint member.fk FOREIGN KEY (user_id) REFERENCES "public"."user"(id)`:                  `constraint member.fk FOREIGN KEY (user_id) REFERENCES "user"(id)`,
+		"public.member":                           "member",
+		"column t.c text default 'public.x'":      "column t.c text default 'public.x'",
+		"column t.c text default 'it''s public.'": "column t.c text default 'it''s public.'",
+		"constraint t.c CHECK (xpublic.f(c))":     "constraint t.c CHECK (xpublic.f(c))",
+		`constraint t.c CHECK ("my public".f(c))`: `constraint t.c CHECK ("my public".f(c))`,
+		"constraint t.c CHECK (other.public.f())": "constraint t.c CHECK (other.public.f())",
+	} {
+		assert.Equal(t, expected, unqualify(line, "public"), line)
+	}
+
+	assert.Equal(t, "policy member.p using (current_tenant_id())", unqualify(`policy member.p using ("Tenant".current_tenant_id())`, "Tenant"))
+}
+
+func TestDifference(t *testing.T) {
+	a := map[string]bool{"table b": true, "table a": true, "table c": true}
+	b := map[string]bool{"table c": true}
+
+	assert.Equal(t, []string{"table a", "table b"}, difference(a, b))
+	assert.Empty(t, difference(b, a))
+}
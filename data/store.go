@@ -1,54 +1,99 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/gocraft/dbr/v2"
 	"github.com/gocraft/dbr/v2/dialect"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/go-playground/validator.v9"
+	"strings"
 	"time"
 )
 
 type Store struct {
-	db        *dbr.Session
-	validator *validator.Validate
+	sess        *dbr.Session
+	db          dbr.SessionRunner
+	validator   *validator.Validate
+	translators *ut.UniversalTranslator
+	isolation   string
+	vars        Vars
+	// actor is the user recorded as the creator or updater of written rows
+	actor dbr.NullString
+	// receiver receives the query events of the store's connection
+	receiver dbr.EventReceiver
+	// observer is notified of the store's operations
+	observer OperationObserver
+	// tracer traces the store's operations and statements
+	tracer trace.Tracer
+	// ctx is the context of the store's statements, e.g. of the inbound request or of the operation that is traced
+	ctx context.Context
 }
 
-func NewStore(d *sql.DB, maxConn int) (*Store, error) {
+func NewStore(d *sql.DB, maxConn int, opts ...StoreOption) (*Store, error) {
+	v, translators, err := newValidator()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		validator:   v,
+		translators: translators,
+		isolation:   IsolationShared,
+		receiver:    &dbr.NullEventReceiver{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	conn := &dbr.Connection{
 		DB:            d,
-		EventReceiver: &dbr.NullEventReceiver{},
+		EventReceiver: s.sessionReceiver(),
 		Dialect:       dialect.PostgreSQL,
 	}
 
 	conn.SetMaxOpenConns(maxConn)
 	sess := conn.NewSession(nil)
-	_, err := sess.Begin()
+	_, err = sess.Begin()
 
 	if err != nil {
 		return nil, errors.New("unable to create data store")
 	}
 
-	v := validator.New()
+	s.sess = sess
+	s.db = sess
+
+	return s, nil
+}
 
-	return &Store{
-		db:        sess,
-		validator: v,
-	}, nil
+// WithEventReceiver makes a Store report the queries it runs to an event receiver, e.g. a QueryReceiver
+func WithEventReceiver(r dbr.EventReceiver) StoreOption {
+	return func(s *Store) {
+		s.receiver = r
+	}
 }
 
 // CreateUser creates a new user
-func (s *Store) CreateUser(u *User) (*User, error) {
+func (s *Store) CreateUser(u *User) (_ *User, err error) {
+	s, end := s.observe("CreateUser")
+	defer end(&err)
+
 	u.Id = uuid.New().String()
+	u.Version = 1
 
 	if err := s.validate(u); err != nil {
 		return nil, err
 	}
 
-	columns := []string{"id", "auth_id", "email", "first_name", "last_name",}
-	err := s.create("user", u, columns)
+	columns := []string{"id", "auth_id", "email", "first_name", "last_name", "version"}
+	err = s.create("user", u, columns)
 
 	if err != nil {
 		return nil, NewDbError(err)
@@ -59,12 +104,15 @@ func (s *Store) CreateUser(u *User) (*User, error) {
 
 // UpdateUser updates an existing user.
 // The variadic "fields" arg should contain the field names that should be updated
-func (s *Store) UpdateUser(id string, u *User, fields ...string) error {
+func (s *Store) UpdateUser(id string, version int, u *User, fields ...string) (err error) {
+	s, end := s.observe("UpdateUser")
+	defer end(&err)
+
 	if err := s.validatePartial(u, fields...); err != nil {
 		return err
 	}
 
-	err := s.update("user", id, fields,
+	err = s.update("user", id, version, fields,
 		set{"AuthId", "auth_id", u.AuthId},
 		set{"Email", "email", u.Email},
 		set{"FirstName", "first_name", u.FirstName},
@@ -75,7 +123,10 @@ func (s *Store) UpdateUser(id string, u *User, fields ...string) error {
 }
 
 // GetUser gets a user by id
-func (s *Store) GetUser(id string) (*User, error) {
+func (s *Store) GetUser(id string) (_ *User, err error) {
+	s, end := s.observe("GetUser")
+	defer end(&err)
+
 	u := &User{}
 	retrieved, count, err := s.getById("user", id, u)
 
@@ -91,24 +142,43 @@ func (s *Store) GetUser(id string) (*User, error) {
 }
 
 // DeleteUser deletes a user
-func (s *Store) DeleteUser(id string) error {
-	err := s.delete("user", id)
+func (s *Store) DeleteUser(id string) (err error) {
+	s, end := s.observe("DeleteUser")
+	defer end(&err)
+
+	err = s.delete("user", id)
 	return NewDbError(err)
 }
 
 // CreateTenant creates a new tenant
-func (s *Store) CreateTenant(t *Tenant) (*Tenant, error) {
+func (s *Store) CreateTenant(t *Tenant) (_ *Tenant, err error) {
+	s, end := s.observe("CreateTenant")
+	defer end(&err)
+
 	t.Id = uuid.New().String()
+	t.Version = 1
 
 	if err := s.validate(t); err != nil {
 		return nil, err
 	}
 
-	columns := []string{"id", "name", "owner_id"}
-	err := s.create("tenant", t, columns)
+	// with schema isolation, the tenant is only created along with its schema
+	err = s.transaction(func(s *Store) error {
+		columns := []string{"id", "name", "owner_id", "parent_id", "version"}
+
+		if err := s.create("tenant", t, columns); err != nil {
+			return NewDbError(err)
+		}
+
+		if s.isolation == IsolationSchema {
+			return s.createTenantSchema(TenantSchemaName(t.Id))
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return nil, NewDbError(err)
+		return nil, err
 	}
 
 	return t, nil
@@ -116,21 +186,87 @@ func (s *Store) CreateTenant(t *Tenant) (*Tenant, error) {
 
 // UpdateTenant updates an existing tenant.
 // The variadic "fields" arg should contain the field names that should be updated
-func (s *Store) UpdateTenant(id string, t *Tenant, fields ...string) error {
+func (s *Store) UpdateTenant(id string, version int, t *Tenant, fields ...string) (err error) {
+	s, end := s.observe("UpdateTenant")
+	defer end(&err)
+
 	if err := s.validatePartial(t, fields...); err != nil {
 		return err
 	}
 
-	err := s.update("tenant", id, fields,
-		set{"Name", "name", t.Name},
-		set{"OwnerId", "owner_id", t.OwnerId},
-	)
+	update := func(s *Store) error {
+		err := s.update("tenant", id, version, fields,
+			set{"Name", "name", t.Name},
+			set{"OwnerId", "owner_id", t.OwnerId},
+			set{"ParentId", "parent_id", t.ParentId},
+		)
+
+		return NewDbError(err)
+	}
+
+	if !includes(fields, "ParentId") || !t.ParentId.Valid {
+		return update(s)
+	}
+
+	// the cycle check and the update must see the same hierarchy, see lockTenantReparent
+	return s.transaction(func(s *Store) error {
+		if err := s.lockTenantReparent(id, t.ParentId.String); err != nil {
+			return err
+		}
+
+		if err := s.checkTenantReparent(id, t.ParentId.String); err != nil {
+			return err
+		}
+
+		return update(s)
+	})
+}
+
+// lockTenantReparent locks a tenant that is moved under parentId along with parentId and its ancestors.
+// Two moves that would only create a cycle together each lock the tenant that the other one moves,
+// so the second move waits for the first to commit and then sees its result in checkTenantReparent
+func (s *Store) lockTenantReparent(id string, parentId string) error {
+	var locked []string
+
+	_, err := s.db.SelectBySql(`
+		with recursive ancestry as (
+			select id, parent_id, array[id] as path from tenant where id = ?
+			union all
+			select parent.id, parent.parent_id, ancestry.path || parent.id from tenant parent
+			join ancestry on parent.id = ancestry.parent_id
+			where not parent.id = any(ancestry.path)
+		)
+		select id from tenant
+		where id = ? or id in (select id from ancestry)
+		order by id
+		for update
+	`, parentId, id).LoadContext(s.context(), &locked)
 
 	return NewDbError(err)
 }
 
+// checkTenantReparent returns an error if moving a tenant under parentId would create a cycle
+func (s *Store) checkTenantReparent(id string, parentId string) error {
+	subtree, err := s.GetTenantSubtree(id)
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range subtree {
+		if t.Id == parentId {
+			return NewError(ErrTenantCycle)
+		}
+	}
+
+	return nil
+}
+
 // GetTenant gets a tenant by id
-func (s *Store) GetTenant(id string) (*Tenant, error) {
+func (s *Store) GetTenant(id string) (_ *Tenant, err error) {
+	s, end := s.observe("GetTenant")
+	defer end(&err)
+
 	t := &Tenant{}
 	retrieved, count, err := s.getById("tenant", id, t)
 
@@ -145,16 +281,198 @@ func (s *Store) GetTenant(id string) (*Tenant, error) {
 	return retrieved.(*Tenant), nil
 }
 
-// DeleteTenant deletes a tenant
-func (s *Store) DeleteTenant(id string) error {
-	err := s.delete("tenant", id)
-	return NewDbError(err)
+// DeleteTenant deletes a tenant. Its child tenants become top-level tenants and its service accounts are deleted with it.
+// A tenant that still has members or joinrequests is not deleted, the ErrTenantNotEmpty error lists them.
+// With schema isolation, the tenant's schema is dropped along with it
+func (s *Store) DeleteTenant(id string) (err error) {
+	s, end := s.observe("DeleteTenant")
+	defer end(&err)
+
+	// the schema is only dropped once the tenant is deleted, and is kept if the transaction rolls back
+	return s.transaction(func(s *Store) error {
+		if err := s.checkTenantEmpty(id); err != nil {
+			return err
+		}
+
+		if err := s.delete("tenant", id); err != nil {
+			return NewDbError(err)
+		}
+
+		if s.isolation == IsolationSchema {
+			return s.dropTenantSchema(id)
+		}
+
+		return nil
+	})
+}
+
+// checkTenantEmpty locks a tenant against new members and joinrequests
+// and returns an ErrTenantNotEmpty error if it still has some
+func (s *Store) checkTenantEmpty(id string) error {
+	var locked []string
+
+	_, err := s.db.SelectBySql("select id from tenant where id = ? for update", id).LoadContext(s.context(), &locked)
+
+	if err != nil {
+		return NewDbError(err)
+	}
+
+	var members []*Member
+	var joinrequests []*Joinrequest
+
+	err = s.ForTenant(id).transaction(func(s *Store) error {
+		if _, err := s.db.Select("*").From("member").Where("tenant_id = ?", id).LoadContext(s.context(), &members); err != nil {
+			return NewDbError(err)
+		}
+
+		_, err := s.db.Select("*").From("joinrequest").Where("tenant_id = ?", id).LoadContext(s.context(), &joinrequests)
+		return NewDbError(err)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var blockers []string
+
+	if len(members) > 0 {
+		blockers = append(blockers, fmt.Sprintf("%d member(s)", len(members)))
+	}
+
+	if len(joinrequests) > 0 {
+		blockers = append(blockers, fmt.Sprintf("%d joinrequest(s)", len(joinrequests)))
+	}
+
+	if len(blockers) > 0 {
+		return newErrorf(ErrTenantNotEmpty, strings.Join(blockers, ", "))
+	}
+
+	return nil
+}
+
+// CreateChildTenant creates a new tenant nested under an existing parent tenant
+func (s *Store) CreateChildTenant(parentId string, t *Tenant) (_ *Tenant, err error) {
+	s, end := s.observe("CreateChildTenant")
+	defer end(&err)
+
+	parent, err := s.GetTenant(parentId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if parent == nil {
+		return nil, NewError(ErrResourceDNE)
+	}
+
+	t.ParentId = dbr.NewNullString(parentId)
+
+	return s.CreateTenant(t)
+}
+
+// GetChildTenants gets the tenants directly nested under a parent tenant
+func (s *Store) GetChildTenants(parentId string) (_ []*Tenant, err error) {
+	s, end := s.observe("GetChildTenants")
+	defer end(&err)
+
+	var t []*Tenant
+
+	_, err = s.db.
+		Select("*").
+		From("tenant").
+		Where("parent_id = ?", parentId).
+		OrderBy("name").
+		LoadContext(s.context(), &t)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return t, nil
+}
+
+// GetTenantSubtree gets a tenant and all of its descendants, ordered by depth.
+// A tenant that is its own descendant is listed once
+func (s *Store) GetTenantSubtree(id string) (_ []*Tenant, err error) {
+	s, end := s.observe("GetTenantSubtree")
+	defer end(&err)
+
+	var t []*Tenant
+
+	stmt := s.db.SelectBySql(`
+		with recursive subtree as (
+			select tenant.*, 0 as depth, array[tenant.id] as path from tenant where id = ?
+			union all
+			select child.*, subtree.depth + 1, subtree.path || child.id from tenant child
+			join subtree on child.parent_id = subtree.id
+			where not child.id = any(subtree.path)
+		)
+		select id, name, owner_id, parent_id, version, created_at, updated_at, created_by, updated_by
+		from subtree order by depth, name
+	`, id)
+
+	_, err = stmt.LoadContext(s.context(), &t)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return t, nil
+}
+
+// GetEffectiveMember resolves a user's membership of a tenant.
+// An active admin of an ancestor tenant is implicitly an admin of the tenant.
+// Returns nil if the user is neither a member of the tenant nor an admin of any of its ancestors.
+func (s *Store) GetEffectiveMember(tenantId string, userId string) (_ *EffectiveMember, err error) {
+	s, end := s.observe("GetEffectiveMember")
+	defer end(&err)
+
+	var rows []struct {
+		Member
+		Depth int `db:"depth"`
+	}
+
+	// the memberships of ancestors belong to other tenants, so they are resolved
+	// by a function that row level security does not restrict
+	stmt := s.db.SelectBySql("select * from effective_memberships(?, ?)", tenantId, userId)
+
+	_, err = stmt.LoadContext(s.context(), &rows)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	em := &EffectiveMember{
+		TenantId: tenantId,
+		UserId:   userId,
+	}
+
+	for _, row := range rows {
+		if row.Depth == 0 {
+			em.MemberId = dbr.NewNullString(row.Id)
+		}
+
+		if row.IsAdmin && !em.IsAdmin {
+			em.IsAdmin = true
+			em.AdminVia = dbr.NewNullString(row.TenantId)
+		}
+	}
+
+	return em, nil
 }
 
 // CreateJoinrequest creates a new joinrequest
-func (s *Store) CreateJoinrequest(jr *Joinrequest) (*Joinrequest, error) {
+func (s *Store) CreateJoinrequest(jr *Joinrequest) (_ *Joinrequest, err error) {
+	s, end := s.observe("CreateJoinrequest")
+	defer end(&err)
+
 	jr.Id = uuid.New().String()
-	jr.CreatedAt = time.Now()
+	jr.Version = 1
+	jr.CreatedAt = now()
 
 	if err := s.validate(jr); err != nil {
 		return nil, err
@@ -168,9 +486,10 @@ func (s *Store) CreateJoinrequest(jr *Joinrequest) (*Joinrequest, error) {
 		"is_from_user",
 		"created_at",
 		"expires_at",
+		"version",
 	}
 
-	err := s.create("joinrequest", jr, columns)
+	err = s.create("joinrequest", jr, columns)
 
 	if err != nil {
 		return nil, NewDbError(err)
@@ -181,12 +500,15 @@ func (s *Store) CreateJoinrequest(jr *Joinrequest) (*Joinrequest, error) {
 
 // UpdateJoinrequest updates an existing joinrequest.
 // The variadic "fields" arg should contain the field names that should be updated
-func (s *Store) UpdateJoinrequest(id string, jr *Joinrequest, fields ...string) error {
+func (s *Store) UpdateJoinrequest(id string, version int, jr *Joinrequest, fields ...string) (err error) {
+	s, end := s.observe("UpdateJoinrequest")
+	defer end(&err)
+
 	if err := s.validatePartial(jr, fields...); err != nil {
 		return err
 	}
 
-	err := s.update("joinrequest", id, fields,
+	err = s.update("joinrequest", id, version, fields,
 		set{"TenantId", "tenant_id", jr.TenantId},
 		set{"UserId", "user_id", jr.UserId},
 		set{"AnonEmail", "anon_email", jr.AnonEmail},
@@ -199,7 +521,10 @@ func (s *Store) UpdateJoinrequest(id string, jr *Joinrequest, fields ...string)
 }
 
 // GetJoinrequest gets a joinrequest by id
-func (s *Store) GetJoinrequest(id string) (*Joinrequest, error) {
+func (s *Store) GetJoinrequest(id string) (_ *Joinrequest, err error) {
+	s, end := s.observe("GetJoinrequest")
+	defer end(&err)
+
 	jr := &Joinrequest{}
 	retrieved, count, err := s.getById("joinrequest", id, jr)
 
@@ -215,14 +540,21 @@ func (s *Store) GetJoinrequest(id string) (*Joinrequest, error) {
 }
 
 // DeleteJoinrequest deletes a joinrequest
-func (s *Store) DeleteJoinrequest(id string) error {
-	err := s.delete("joinrequest", id)
+func (s *Store) DeleteJoinrequest(id string) (err error) {
+	s, end := s.observe("DeleteJoinrequest")
+	defer end(&err)
+
+	err = s.delete("joinrequest", id)
 	return NewDbError(err)
 }
 
 // CreateMember creates a new member
-func (s *Store) CreateMember(m *Member) (*Member, error) {
+func (s *Store) CreateMember(m *Member) (_ *Member, err error) {
+	s, end := s.observe("CreateMember")
+	defer end(&err)
+
 	m.Id = uuid.New().String()
+	m.Version = 1
 
 	if err := s.validate(m); err != nil {
 		return nil, err
@@ -235,9 +567,10 @@ func (s *Store) CreateMember(m *Member) (*Member, error) {
 		"alias",
 		"is_admin",
 		"is_inactive",
+		"version",
 	}
 
-	err := s.create("member", m, columns)
+	err = s.create("member", m, columns)
 
 	if err != nil {
 		return nil, NewDbError(err)
@@ -248,12 +581,15 @@ func (s *Store) CreateMember(m *Member) (*Member, error) {
 
 // UpdateMember updates an existing member.
 // The variadic "fields" arg should contain the field names that should be updated
-func (s *Store) UpdateMember(id string, m *Member, fields ...string) error {
+func (s *Store) UpdateMember(id string, version int, m *Member, fields ...string) (err error) {
+	s, end := s.observe("UpdateMember")
+	defer end(&err)
+
 	if err := s.validatePartial(m, fields...); err != nil {
 		return err
 	}
 
-	err := s.update("member", id, fields,
+	err = s.update("member", id, version, fields,
 		set{"TenantId", "tenant_id", m.TenantId},
 		set{"UserId", "user_id", m.UserId},
 		set{"Alias", "alias", m.Alias},
@@ -265,7 +601,10 @@ func (s *Store) UpdateMember(id string, m *Member, fields ...string) error {
 }
 
 // GetMember gets a member by id
-func (s *Store) GetMember(id string) (*Member, error) {
+func (s *Store) GetMember(id string) (_ *Member, err error) {
+	s, end := s.observe("GetMember")
+	defer end(&err)
+
 	m := &Member{}
 	retrieved, count, err := s.getById("member", id, m)
 
@@ -281,58 +620,315 @@ func (s *Store) GetMember(id string) (*Member, error) {
 }
 
 // DeleteMember deletes a member
-func (s *Store) DeleteMember(id string) error {
-	err := s.delete("member", id)
+func (s *Store) DeleteMember(id string) (err error) {
+	s, end := s.observe("DeleteMember")
+	defer end(&err)
+
+	err = s.delete("member", id)
 	return NewDbError(err)
 }
 
-func (s *Store) GetUsers(ids []string) ([]*User, error) {
+func (s *Store) GetUsers(ids []string) (_ []*User, err error) {
+	s, end := s.observe("GetUsers")
+	defer end(&err)
+
 	var u []*User
 
 	stmt := s.db.SelectBySql(`select * from "user" where id = any(?)`, pq.Array(ids))
 
-	_, err := stmt.Load(&u)
+	_, err = stmt.LoadContext(s.context(), &u)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return u, nil
+}
+
+// GetUserByAuthId gets a user by the id of their external identity
+func (s *Store) GetUserByAuthId(authId string) (_ *User, err error) {
+	s, end := s.observe("GetUserByAuthId")
+	defer end(&err)
+
+	u := &User{}
+
+	count, err := s.db.
+		Select("*").
+		From(quotes("user")).
+		Where("auth_id = ?", authId).
+		LoadContext(s.context(), u)
 
 	if err != nil {
 		return nil, NewDbError(err)
 	}
 
+	if count == 0 {
+		return nil, nil
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail gets a user by email
+func (s *Store) GetUserByEmail(email string) (_ *User, err error) {
+	s, end := s.observe("GetUserByEmail")
+	defer end(&err)
+
+	u := &User{}
+
+	count, err := s.db.
+		Select("*").
+		From(quotes("user")).
+		Where("email = ?", email).
+		LoadContext(s.context(), u)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
 	return u, nil
 }
 
-func (s *Store) GetUserByEmail(email string) (*User, error) {
-	return nil, nil
+// GetTenants gets all tenants ordered by name
+func (s *Store) GetTenants() (_ []*Tenant, err error) {
+	s, end := s.observe("GetTenants")
+	defer end(&err)
+
+	var t []*Tenant
+
+	_, err = s.db.
+		Select("*").
+		From("tenant").
+		OrderBy("name").
+		LoadContext(s.context(), &t)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return t, nil
 }
 
-func (s *Store) GetMemberByUserId(userId string) (*Member, error) {
-	return nil, nil
+// GetMemberByUserId gets a membership of a user, the one in the tenant with the lowest id if the user is a member of several tenants.
+//
+// Deprecated: a user can be a member of several tenants, use GetTenantMemberByUserId or GetMembersByUserId
+func (s *Store) GetMemberByUserId(userId string) (_ *Member, err error) {
+	s, end := s.observe("GetMemberByUserId")
+	defer end(&err)
+
+	var m []*Member
+
+	_, err = s.db.
+		Select("*").
+		From("member").
+		Where("user_id = ?", userId).
+		OrderBy("tenant_id").
+		Limit(1).
+		LoadContext(s.context(), &m)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	return m[0], nil
 }
 
-func (s *Store) GetMembersByTenantId(tenantId string) ([]*Member, error) {
-	return nil, nil
+// GetTenantMemberByUserId gets the membership of a user in a tenant
+func (s *Store) GetTenantMemberByUserId(tenantId string, userId string) (_ *Member, err error) {
+	s, end := s.observe("GetTenantMemberByUserId")
+	defer end(&err)
+
+	m := &Member{}
+
+	retrieved, count, err := s.getWhere("member", dbr.And(
+		dbr.Eq("tenant_id", tenantId),
+		dbr.Eq("user_id", userId),
+	), m)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return retrieved.(*Member), nil
 }
 
-func (s *Store) GetMembersByUserId(userId string) ([]*Member, error) {
-	return nil, nil
+// GetMembersByTenantId gets the members of a tenant
+func (s *Store) GetMembersByTenantId(tenantId string) (_ []*Member, err error) {
+	s, end := s.observe("GetMembersByTenantId")
+	defer end(&err)
+
+	var m []*Member
+
+	_, err = s.db.
+		Select("*").
+		From("member").
+		Where("tenant_id = ?", tenantId).
+		LoadContext(s.context(), &m)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return m, nil
 }
 
-func (s *Store) GetJoinrequestsByUserId(userId string) ([]*Joinrequest, error) {
-	return nil, nil
+// GetMembersByUserId gets the memberships of a user across tenants
+func (s *Store) GetMembersByUserId(userId string) (_ []*Member, err error) {
+	s, end := s.observe("GetMembersByUserId")
+	defer end(&err)
+
+	var m []*Member
+
+	_, err = s.db.
+		Select("*").
+		From("member").
+		Where("user_id = ?", userId).
+		LoadContext(s.context(), &m)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return m, nil
 }
 
-func (s *Store) GetJoinrequestsByTenantId(tenantId string) ([]*Joinrequest, error) {
-	return nil, nil
+// GetJoinrequestsByUserId gets the joinrequests of a user
+func (s *Store) GetJoinrequestsByUserId(userId string) (_ []*Joinrequest, err error) {
+	s, end := s.observe("GetJoinrequestsByUserId")
+	defer end(&err)
+
+	var jr []*Joinrequest
+
+	_, err = s.db.
+		Select("*").
+		From("joinrequest").
+		Where("user_id = ?", userId).
+		OrderBy("created_at").
+		LoadContext(s.context(), &jr)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return jr, nil
 }
 
-func (s *Store) GetJoinrequestsByAnonEmail(email string) ([]*Joinrequest, error) {
-	return nil, nil
+// GetJoinrequestsByTenantId gets the joinrequests of a tenant
+func (s *Store) GetJoinrequestsByTenantId(tenantId string) (_ []*Joinrequest, err error) {
+	s, end := s.observe("GetJoinrequestsByTenantId")
+	defer end(&err)
+
+	var jr []*Joinrequest
+
+	_, err = s.db.
+		Select("*").
+		From("joinrequest").
+		Where("tenant_id = ?", tenantId).
+		OrderBy("created_at").
+		LoadContext(s.context(), &jr)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return jr, nil
 }
 
-func (s *Store) CheckTenantMember(tenantId string, memberId string) (bool, error) {
-	return false, nil
+// GetJoinrequestsByAnonEmail gets the joinrequests sent to an email address that did not belong to a user
+func (s *Store) GetJoinrequestsByAnonEmail(email string) (_ []*Joinrequest, err error) {
+	s, end := s.observe("GetJoinrequestsByAnonEmail")
+	defer end(&err)
+
+	var jr []*Joinrequest
+
+	_, err = s.db.
+		Select("*").
+		From("joinrequest").
+		Where("anon_email = ?", email).
+		OrderBy("created_at").
+		LoadContext(s.context(), &jr)
+
+	if err != nil {
+		return nil, NewDbError(err)
+	}
+
+	return jr, nil
 }
 
-func (s *Store) InviteByEmail(tenantId string, email string) (*Joinrequest, error) {
+// CountTenants counts all tenants
+func (s *Store) CountTenants() (_ int, err error) {
+	s, end := s.observe("CountTenants")
+	defer end(&err)
+
+	var count int
+
+	err = s.db.
+		Select("count(*)").
+		From("tenant").
+		LoadOneContext(s.context(), &count)
+
+	if err != nil {
+		return 0, NewDbError(err)
+	}
+
+	return count, nil
+}
+
+// CountPendingJoinrequests counts the joinrequests of all tenants that are neither accepted nor declined and have not expired
+func (s *Store) CountPendingJoinrequests() (_ int, err error) {
+	s, end := s.observe("CountPendingJoinrequests")
+	defer end(&err)
+
+	var count int
+
+	// the joinrequests belong to every tenant, so they are counted
+	// by a function that row level security does not restrict
+	err = s.db.
+		SelectBySql("select count_pending_joinrequests(?)", now()).
+		LoadOneContext(s.context(), &count)
+
+	if err != nil {
+		return 0, NewDbError(err)
+	}
+
+	return count, nil
+}
+
+// CheckTenantMember checks whether a member belongs to a tenant
+func (s *Store) CheckTenantMember(tenantId string, memberId string) (_ bool, err error) {
+	s, end := s.observe("CheckTenantMember")
+	defer end(&err)
+
+	_, count, err := s.getWhere("member", dbr.And(
+		dbr.Eq("id", memberId),
+		dbr.Eq("tenant_id", tenantId),
+	), &Member{})
+
+	if err != nil {
+		return false, NewDbError(err)
+	}
+
+	return count > 0, nil
+}
+
+// InviteByEmail invites the owner of an email address to a tenant.
+// If no user has the email address, the invitation is addressed to the email address until a user accepts it
+func (s *Store) InviteByEmail(tenantId string, email string) (_ *Joinrequest, err error) {
+	s, end := s.observe("InviteByEmail")
+	defer end(&err)
+
 	u, err := s.GetUserByEmail(email)
 
 	if err != nil {
@@ -349,15 +945,13 @@ func (s *Store) InviteByEmail(tenantId string, email string) (*Joinrequest, erro
 		return j, err
 	}
 
-	m, err := s.GetMemberByUserId(u.Id)
+	m, err := s.GetTenantMemberByUserId(tenantId, u.Id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	alreadyMember, err := s.CheckTenantMember(tenantId, m.Id)
-
-	if alreadyMember == false {
+	if m == nil {
 		// send invite to user
 		j, err := s.CreateJoinrequest(&Joinrequest{
 			TenantId: tenantId,
@@ -367,33 +961,183 @@ func (s *Store) InviteByEmail(tenantId string, email string) (*Joinrequest, erro
 		return j, err
 	}
 
-	return nil, errors.New(ErrAlreadyMember)
+	return nil, NewError(ErrAlreadyMember)
 }
 
-func (s *Store) AcceptInvitation(joinrequestId string) error {
-	_, err := s.GetJoinrequest(joinrequestId)
+// AcceptInvitation accepts an open joinrequest and makes its user a member of the tenant.
+// A joinrequest addressed to an email address is accepted on behalf of the user that has the email address
+func (s *Store) AcceptInvitation(joinrequestId string) (err error) {
+	s, end := s.observe("AcceptInvitation")
+	defer end(&err)
+
+	_, err = s.AcceptInvitationMember(joinrequestId)
+	return err
+}
+
+// AcceptInvitationMember accepts an open joinrequest like AcceptInvitation and returns the member it creates
+func (s *Store) AcceptInvitationMember(joinrequestId string) (_ *Member, err error) {
+	s, end := s.observe("AcceptInvitationMember")
+	defer end(&err)
+
+	var m *Member
+
+	err = s.transaction(func(s *Store) error {
+		jr, err := s.openJoinrequest(joinrequestId)
+
+		if err != nil {
+			return err
+		}
+
+		userId := jr.UserId.String
+
+		if !jr.UserId.Valid {
+			u, err := s.GetUserByEmail(jr.AnonEmail.String)
+
+			if err != nil {
+				return err
+			}
+
+			if u == nil {
+				return NewError(ErrInvitationUserDNE)
+			}
+
+			userId = u.Id
+		}
+
+		existing, err := s.GetTenantMemberByUserId(jr.TenantId, userId)
+
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			return NewError(ErrAlreadyMember)
+		}
+
+		err = s.UpdateJoinrequest(jr.Id, AnyVersion, &Joinrequest{
+			UserId:     dbr.NewNullString(userId),
+			IsAccepted: dbr.NewNullBool(true),
+		}, "UserId", "IsAccepted")
+
+		if err != nil {
+			return err
+		}
+
+		m, err = s.CreateMember(&Member{
+			TenantId: jr.TenantId,
+			UserId:   userId,
+		})
 
-	if err != nil {
 		return err
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	return m, nil
+}
+
+// RevokeInvitation closes an open joinrequest without accepting it
+func (s *Store) RevokeInvitation(joinrequestId string) (err error) {
+	s, end := s.observe("RevokeInvitation")
+	defer end(&err)
+
+	return s.transaction(func(s *Store) error {
+		jr, err := s.openJoinrequest(joinrequestId)
 
+		if err != nil {
+			return err
+		}
 
-    return nil
+		return s.UpdateJoinrequest(jr.Id, AnyVersion, &Joinrequest{IsAccepted: dbr.NewNullBool(false)}, "IsAccepted")
+	})
 }
 
-func (s *Store) PromoteMember(id string) error {
-	return nil
+// openJoinrequest gets a joinrequest that has been neither accepted nor revoked and has not expired
+func (s *Store) openJoinrequest(id string) (*Joinrequest, error) {
+	jr, err := s.GetJoinrequest(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if jr == nil {
+		return nil, NewError(ErrResourceDNE)
+	}
+
+	if jr.IsAccepted.Valid {
+		return nil, NewError(ErrInvitationClosed)
+	}
+
+	if jr.ExpiresAt.Valid && jr.ExpiresAt.Time.Before(time.Now()) {
+		return nil, NewError(ErrInvitationExpired)
+	}
+
+	return jr, nil
 }
 
-func (s *Store) DemoteMember(id string) error {
-	return nil
+// TransferTenant makes a user the owner of a tenant.
+// The new owner becomes an active admin member of the tenant if they are not one already
+func (s *Store) TransferTenant(tenantId string, userId string) (err error) {
+	s, end := s.observe("TransferTenant")
+	defer end(&err)
+
+	return s.transaction(func(s *Store) error {
+		err := s.UpdateTenant(tenantId, AnyVersion, &Tenant{OwnerId: userId}, "OwnerId")
+
+		if err != nil {
+			return err
+		}
+
+		m, err := s.GetTenantMemberByUserId(tenantId, userId)
+
+		if err != nil {
+			return err
+		}
+
+		if m == nil {
+			_, err := s.CreateMember(&Member{
+				TenantId: tenantId,
+				UserId:   userId,
+				IsAdmin:  true,
+			})
+
+			return err
+		}
+
+		return s.UpdateMember(m.Id, AnyVersion, &Member{IsAdmin: true, IsInactive: false}, "IsAdmin", "IsInactive")
+	})
 }
 
-func (s *Store) ActivateMember(id string) error {
-	return nil
+// PromoteMember makes a member an admin of their tenant
+func (s *Store) PromoteMember(id string) (err error) {
+	s, end := s.observe("PromoteMember")
+	defer end(&err)
+
+	return s.UpdateMember(id, AnyVersion, &Member{IsAdmin: true}, "IsAdmin")
 }
 
-func (s *Store) DeactivateMember(id string) error {
-	return nil
+// DemoteMember revokes a member's admin rights
+func (s *Store) DemoteMember(id string) (err error) {
+	s, end := s.observe("DemoteMember")
+	defer end(&err)
+
+	return s.UpdateMember(id, AnyVersion, &Member{IsAdmin: false}, "IsAdmin")
+}
+
+// ActivateMember reactivates a deactivated member
+func (s *Store) ActivateMember(id string) (err error) {
+	s, end := s.observe("ActivateMember")
+	defer end(&err)
+
+	return s.UpdateMember(id, AnyVersion, &Member{IsInactive: false}, "IsInactive")
+}
+
+// DeactivateMember deactivates a member without removing them from their tenant
+func (s *Store) DeactivateMember(id string) (err error) {
+	s, end := s.observe("DeactivateMember")
+	defer end(&err)
+
+	return s.UpdateMember(id, AnyVersion, &Member{IsInactive: true}, "IsInactive")
 }
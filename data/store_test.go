@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"flag"
@@ -10,6 +11,7 @@ import (
 	"gopkg.in/testfixtures.v2"
 	"log"
 	"testing"
+	"time"
 )
 
 type StoreTestSuite struct {
@@ -39,8 +41,9 @@ func (s *StoreTestSuite) SetupSuite() {
 
 	s.fixtures = fixtures
 
-	// create store
-	store, err := NewStore(d, 10)
+	// create store. It connects like the admin commands, as a role that is neither a superuser nor the owner of the tables,
+	// so that row level security applies to it
+	store, err := NewStore(connectAdmin(s), 10)
 
 	if err != nil {
 		s.T().Fatalf("failed to create store: %s", err)
@@ -87,6 +90,66 @@ func connect(s *StoreTestSuite) *sql.DB {
 	return d
 }
 
+// connectAdmin connects as the admin role of the environment, see OpenAdminDb
+func connectAdmin(s *StoreTestSuite) *sql.DB {
+	vars, err := LoadEnvVars(envPath)
+
+	if err != nil {
+		s.T().Fatalf("failed to load environment variables: %s", err)
+	}
+
+	if vars.AdminRole == "" {
+		s.T().Fatal("missing DB_ADMIN_ROLE in the .env file")
+	}
+
+	d, err := OpenAdminDb(vars)
+
+	if err != nil {
+		s.T().Fatalf("failed to connect to database: %s", err)
+	}
+
+	return d
+}
+
+// connectServing connects as a role like the one that serves tenants, which is granted the privileges
+// on the tables but is neither a superuser nor granted xtenancy_rls_bypass
+func connectServing(s *StoreTestSuite) *sql.DB {
+	d := connect(s)
+	defer d.Close()
+
+	_, err := d.Exec(`
+		do
+		$$
+			begin
+				if not exists(select from pg_roles where rolname = 'xtenancy_serving_test') then
+					create role xtenancy_serving_test nologin;
+				end if;
+			end
+		$$;
+		grant usage on schema public to xtenancy_serving_test;
+		grant select, insert, update, delete on all tables in schema public to xtenancy_serving_test;
+	`)
+
+	if err != nil {
+		s.T().Fatalf("failed to create the serving role: %s", err)
+	}
+
+	vars, err := LoadEnvVars(envPath)
+
+	if err != nil {
+		s.T().Fatalf("failed to load environment variables: %s", err)
+	}
+
+	vars.AdminRole = "xtenancy_serving_test"
+	serving, err := OpenAdminDb(vars)
+
+	if err != nil {
+		s.T().Fatalf("failed to connect to database: %s", err)
+	}
+
+	return serving
+}
+
 func clearTables(db *sql.DB) error {
 	_, err := db.Query(`
 		truncate table "user" cascade;
@@ -115,8 +178,9 @@ func (s *StoreTestSuite) TestGetUser() {
 		Email:     "a@a.a",
 		FirstName: "firstName0",
 		LastName:  "lastName0",
+		Version:   1,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
 	u, _ = s.Store.GetUser("00000000-0000-0000-7777-000000000000")
 	s.Assert().Nil(u)
@@ -125,7 +189,7 @@ func (s *StoreTestSuite) TestUpdateUser() {
 	id := "00000000-0000-0000-0000-000000000001"
 
 	// doesn't update an unspecified field field
-	_ = s.Store.UpdateUser(id, &User{FirstName: "abc",})
+	_ = s.Store.UpdateUser(id, AnyVersion, &User{FirstName: "abc",})
 	u, _ := s.Store.GetUser(id)
 	expected := &User{
 		Id:        id,
@@ -133,11 +197,12 @@ func (s *StoreTestSuite) TestUpdateUser() {
 		Email:     "b@b.b",
 		FirstName: "firstName1",
 		LastName:  "lastName1",
+		Version:   1,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
 	// updates a specified field
-	_ = s.Store.UpdateUser(id, &User{FirstName: "abc",}, "FirstName")
+	_ = s.Store.UpdateUser(id, AnyVersion, &User{FirstName: "abc",}, "FirstName")
 	u, _ = s.Store.GetUser(id)
 	expected = &User{
 		Id:        id,
@@ -145,11 +210,12 @@ func (s *StoreTestSuite) TestUpdateUser() {
 		Email:     "b@b.b",
 		FirstName: "abc",
 		LastName:  "lastName1",
+		Version:   2,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
 	//
-	err := s.Store.UpdateUser("00000000-0000-0000-7777-000000000001", &User{FirstName: "abc"}, "FirstName")
+	err := s.Store.UpdateUser("00000000-0000-0000-7777-000000000001", AnyVersion, &User{FirstName: "abc"}, "FirstName")
 	s.Assert().Equal(ErrResourceDNE, err.Error())
 	s.Assert().Nil(errors.Unwrap(err))
 }
@@ -163,7 +229,7 @@ func (s *StoreTestSuite) TestCreateUser() {
 	})
 
 	retrieved, _ := s.Store.GetUser(created.Id)
-	s.Assert().EqualValues(created, retrieved)
+	s.Assert().EqualValues(created.comparable(), retrieved.comparable())
 }
 
 func (s *StoreTestSuite) TestDeleteUser() {
@@ -189,8 +255,9 @@ func (s *StoreTestSuite) TestGetTenant() {
 		Id:   id,
 		Name: "name0",
 		OwnerId: "00000000-0000-0000-0000-000000000000",
+		Version: 1,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
 	u, _ = s.Store.GetTenant("00000000-0000-0000-7777-000000000000")
 	s.Assert().Nil(u)
@@ -199,26 +266,28 @@ func (s *StoreTestSuite) TestGetTenant() {
 func (s *StoreTestSuite) TestUpdateTenant() {
 	id := "00000000-0000-0000-0000-000000000001"
 
-	_ = s.Store.UpdateTenant(id, &Tenant{Name: "abc",})
+	_ = s.Store.UpdateTenant(id, AnyVersion, &Tenant{Name: "abc",})
 
 	u, _ := s.Store.GetTenant(id)
 	expected := &Tenant{
 		Id:   id,
 		Name: "name1",
 		OwnerId: "00000000-0000-0000-0000-000000000000",
+		Version: 1,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
-	_ = s.Store.UpdateTenant(id, &Tenant{Name: "abc",}, "Name")
+	_ = s.Store.UpdateTenant(id, AnyVersion, &Tenant{Name: "abc",}, "Name")
 	u, _ = s.Store.GetTenant(id)
 	expected = &Tenant{
 		Id:   id,
 		Name: "abc",
 		OwnerId: "00000000-0000-0000-0000-000000000000",
+		Version: 2,
 	}
-	s.Assert().EqualValues(expected, u)
+	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
-	err := s.Store.UpdateTenant("00000000-0000-0000-7777-000000000001", &Tenant{Name: "abc"}, "Name")
+	err := s.Store.UpdateTenant("00000000-0000-0000-7777-000000000001", AnyVersion, &Tenant{Name: "abc"}, "Name")
 	s.Assert().Equal(ErrResourceDNE, err.Error())
 	s.Assert().Nil(errors.Unwrap(err))
 }
@@ -231,7 +300,7 @@ func (s *StoreTestSuite) TestCreateTenant() {
 
 	retrieved, _ := s.Store.GetTenant(created.Id)
 
-	s.Assert().EqualValues(created, retrieved)
+	s.Assert().EqualValues(created.comparable(), retrieved.comparable())
 }
 
 func (s *StoreTestSuite) TestDeleteTenant() {
@@ -247,6 +316,122 @@ func (s *StoreTestSuite) TestDeleteTenant() {
 	err := s.Store.DeleteTenant("00000000-0000-0000-7777-000000000003")
 	s.Assert().Equal(ErrResourceDNE, err.Error())
 	s.Assert().Nil(errors.Unwrap(err))
+
+	// a tenant with members and joinrequests is kept
+	err = s.Store.DeleteTenant("00000000-0000-0000-0000-000000000000")
+	s.Assert().True(errors.Is(err, ErrPrecondition))
+	s.Assert().Equal(ErrTenantNotEmpty+": 1 member(s), 2 joinrequest(s)", err.Error())
+
+	retrieved, _ = s.Store.GetTenant("00000000-0000-0000-0000-000000000000")
+	s.Assert().NotNil(retrieved)
+}
+
+func (s *StoreTestSuite) TestCreateChildTenant() {
+	parentId := "00000000-0000-0000-0000-000000000001"
+	created, err := s.Store.CreateChildTenant(parentId, &Tenant{
+		Name:    "child",
+		OwnerId: "00000000-0000-0000-0000-000000000000",
+	})
+	s.Assert().Nil(err)
+	s.Assert().Equal(dbr.NewNullString(parentId), created.ParentId)
+
+	children, _ := s.Store.GetChildTenants(parentId)
+	s.Assert().Len(children, 1)
+	s.Assert().Equal(created.Id, children[0].Id)
+
+	_, err = s.Store.CreateChildTenant("00000000-0000-0000-7777-000000000001", &Tenant{
+		Name:    "orphan",
+		OwnerId: "00000000-0000-0000-0000-000000000000",
+	})
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+}
+
+func (s *StoreTestSuite) TestGetTenantSubtree() {
+	subtree, _ := s.Store.GetTenantSubtree("00000000-0000-0000-0000-000000000000")
+
+	var ids []string
+	for _, t := range subtree {
+		ids = append(ids, t.Id)
+	}
+
+	expected := []string{
+		"00000000-0000-0000-0000-000000000000",
+		"00000000-0000-0000-0000-000000000004",
+		"00000000-0000-0000-0000-000000000005",
+	}
+	s.Assert().Equal(expected, ids)
+
+	// the tenants are complete, so that they can be updated with their version
+	for _, t := range subtree {
+		retrieved, _ := s.Store.GetTenant(t.Id)
+		s.Assert().Equal(retrieved, t)
+	}
+}
+
+func (s *StoreTestSuite) TestUpdateTenantParent() {
+	id := "00000000-0000-0000-0000-000000000000"
+
+	// cannot nest a tenant under its own descendant
+	err := s.Store.UpdateTenant(id, AnyVersion, &Tenant{ParentId: dbr.NewNullString("00000000-0000-0000-0000-000000000005")}, "ParentId")
+	s.Assert().Equal(ErrTenantCycle, err.Error())
+
+	err = s.Store.UpdateTenant(id, AnyVersion, &Tenant{ParentId: dbr.NewNullString(id)}, "ParentId")
+	s.Assert().Equal(ErrTenantCycle, err.Error())
+
+	// can nest under an unrelated tenant
+	err = s.Store.UpdateTenant(id, AnyVersion, &Tenant{ParentId: dbr.NewNullString("00000000-0000-0000-0000-000000000001")}, "ParentId")
+	s.Assert().Nil(err)
+}
+
+func (s *StoreTestSuite) TestDeleteParentTenant() {
+	err := s.Store.DeleteTenant("00000000-0000-0000-0000-000000000004")
+	s.Assert().Nil(err)
+
+	// the child is detached rather than deleted
+	child, _ := s.Store.GetTenant("00000000-0000-0000-0000-000000000005")
+	s.Assert().NotNil(child)
+	s.Assert().False(child.ParentId.Valid)
+}
+
+func (s *StoreTestSuite) TestGetEffectiveMember() {
+	// admin of an ancestor is an admin of the descendant
+	em, _ := s.Store.GetEffectiveMember("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000001")
+	expected := &EffectiveMember{
+		TenantId: "00000000-0000-0000-0000-000000000005",
+		UserId:   "00000000-0000-0000-0000-000000000001",
+		IsAdmin:  true,
+		AdminVia: dbr.NewNullString("00000000-0000-0000-0000-000000000000"),
+	}
+	s.Assert().Equal(expected, em)
+
+	// direct non-admin member
+	em, _ = s.Store.GetEffectiveMember("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000000")
+	expected = &EffectiveMember{
+		TenantId: "00000000-0000-0000-0000-000000000005",
+		UserId:   "00000000-0000-0000-0000-000000000000",
+		MemberId: dbr.NewNullString("00000000-0000-0000-0000-000000000001"),
+	}
+	s.Assert().Equal(expected, em)
+
+	// non-admin membership does not inherit upward
+	em, _ = s.Store.GetEffectiveMember("00000000-0000-0000-0000-000000000004", "00000000-0000-0000-0000-000000000000")
+	s.Assert().Nil(em)
+
+	// the role that serves tenants resolves the admin membership of the ancestor too
+	d := connectServing(s)
+	defer d.Close()
+
+	store, err := NewStore(d, 2)
+	s.Require().Nil(err)
+
+	em, err = store.GetEffectiveMember("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000001")
+	s.Assert().Nil(err)
+	s.Assert().Equal(&EffectiveMember{
+		TenantId: "00000000-0000-0000-0000-000000000005",
+		UserId:   "00000000-0000-0000-0000-000000000001",
+		IsAdmin:  true,
+		AdminVia: dbr.NewNullString("00000000-0000-0000-0000-000000000000"),
+	}, em)
 }
 
 func (s *StoreTestSuite) TestGetJoinrequest() {
@@ -273,7 +458,7 @@ func (s *StoreTestSuite) TestUpdateJoinrequest() {
 	id := "00000000-0000-0000-0000-000000000001"
 
 	// doesn't update without specifying field
-	_ = s.Store.UpdateJoinrequest(id, &Joinrequest{IsAccepted: dbr.NewNullBool(true)})
+	_ = s.Store.UpdateJoinrequest(id, AnyVersion, &Joinrequest{IsAccepted: dbr.NewNullBool(true)})
 	u, _ := s.Store.GetJoinrequest(id)
 	expected := &Joinrequest{
 		Id:         id,
@@ -283,7 +468,7 @@ func (s *StoreTestSuite) TestUpdateJoinrequest() {
 	s.Assert().EqualValues(expected.comparable(), u.comparable())
 
 	// updates specified field
-	_ = s.Store.UpdateJoinrequest(id, &Joinrequest{IsAccepted: dbr.NewNullBool(true)}, "IsAccepted")
+	_ = s.Store.UpdateJoinrequest(id, AnyVersion, &Joinrequest{IsAccepted: dbr.NewNullBool(true)}, "IsAccepted")
 	u, _ = s.Store.GetJoinrequest(id)
 	expected = &Joinrequest{
 		Id:         id,
@@ -295,6 +480,7 @@ func (s *StoreTestSuite) TestUpdateJoinrequest() {
 	// error on non-existent resource
 	err := s.Store.UpdateJoinrequest(
 		"00000000-0000-0000-7777-000000000001",
+		AnyVersion,
 		&Joinrequest{IsAccepted: dbr.NewNullBool(true)},
 		"IsAccepted",
 	)
@@ -325,3 +511,626 @@ func (s *StoreTestSuite) TestDeleteJoinrequest() {
 	s.Assert().Equal(ErrResourceDNE, err.Error())
 	s.Assert().Nil(errors.Unwrap(err))
 }
+
+func (s *StoreTestSuite) TestApiKeyLifecycle() {
+	sa, err := s.Store.CreateServiceAccount(&ServiceAccount{
+		TenantId: "00000000-0000-0000-0000-000000000000",
+		Name:     "ci",
+		Roles:    []string{"admin"},
+	})
+	s.Assert().Nil(err)
+
+	k, key, err := s.Store.CreateApiKey(sa.Id)
+	s.Assert().Nil(err)
+	s.Assert().NotContains(string(k.SecretHash), key)
+
+	// authenticates and records usage
+	identity, err := s.Store.AuthenticateApiKey(key)
+	s.Assert().Nil(err)
+	expected := &ApiKeyIdentity{
+		ApiKeyId:         k.Id,
+		ServiceAccountId: sa.Id,
+		TenantId:         "00000000-0000-0000-0000-000000000000",
+		Roles:            []string{"admin"},
+	}
+	s.Assert().Equal(expected, identity)
+
+	retrieved, _ := s.Store.GetApiKey(k.Id)
+	s.Assert().True(retrieved.LastUsedAt.Valid)
+
+	// rejects a wrong secret
+	_, err = s.Store.AuthenticateApiKey(apiKeyScheme + k.Prefix + ".wrong")
+	s.Assert().Equal(ErrInvalidApiKey, err.Error())
+
+	// rotation revokes the old key
+	rotated, rotatedKey, err := s.Store.RotateApiKey(k.Id)
+	s.Assert().Nil(err)
+	s.Assert().NotEqual(k.Id, rotated.Id)
+
+	_, err = s.Store.AuthenticateApiKey(key)
+	s.Assert().Equal(ErrInvalidApiKey, err.Error())
+
+	identity, err = s.Store.AuthenticateApiKey(rotatedKey)
+	s.Assert().Nil(err)
+	s.Assert().Equal(rotated.Id, identity.ApiKeyId)
+
+	// a revoked key cannot be rotated again, so no second key is minted from it
+	_, _, err = s.Store.RotateApiKey(k.Id)
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+
+	keys, _ := s.Store.GetApiKeysByServiceAccountId(sa.Id)
+	s.Assert().Len(keys, 2)
+
+	// revocation
+	s.Assert().Nil(s.Store.RevokeApiKey(rotated.Id))
+	s.Assert().Equal(ErrResourceDNE, s.Store.RevokeApiKey(rotated.Id).Error())
+
+	_, err = s.Store.AuthenticateApiKey(rotatedKey)
+	s.Assert().Equal(ErrInvalidApiKey, err.Error())
+}
+
+func (s *StoreTestSuite) TestGetUserByAuthId() {
+	u, _ := s.Store.GetUserByAuthId("00000000-0000-0000-0000-000000000001")
+	s.Assert().Equal("00000000-0000-0000-0000-000000000001", u.Id)
+
+	u, _ = s.Store.GetUserByAuthId("00000000-0000-0000-7777-000000000001")
+	s.Assert().Nil(u)
+}
+
+func (s *StoreTestSuite) TestTenantStore() {
+	ts := s.Store.ForTenant("00000000-0000-0000-0000-000000000005")
+	ownId := "00000000-0000-0000-0000-000000000001"
+	otherId := "00000000-0000-0000-0000-000000000000"
+
+	members, _ := ts.GetMembers()
+	s.Assert().Len(members, 1)
+	s.Assert().Equal(ownId, members[0].Id)
+
+	m, _ := ts.GetMember(ownId)
+	s.Assert().NotNil(m)
+
+	// members of other tenants are not visible
+	m, _ = ts.GetMember(otherId)
+	s.Assert().Nil(m)
+
+	err := ts.UpdateMember(otherId, AnyVersion, &Member{IsAdmin: false}, "IsAdmin")
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+
+	err = ts.DeleteMember(otherId)
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+
+	// creation is pinned to the tenant
+	jr, _ := ts.CreateJoinrequest(&Joinrequest{TenantId: "00000000-0000-0000-0000-000000000000"})
+	s.Assert().Equal(ts.TenantId(), jr.TenantId)
+
+	jrs, _ := ts.GetJoinrequests()
+	s.Assert().Len(jrs, 1)
+
+	retrieved, _ := ts.GetJoinrequest("00000000-0000-0000-0000-000000000000")
+	s.Assert().Nil(retrieved)
+}
+
+func (s *StoreTestSuite) TestRowLevelSecurity() {
+	d := connect(s)
+	defer d.Close()
+
+	// the suite connects as the owner of the tables, whom row level security does not restrict by default.
+	// The checks run as a role like the one that serves tenants, in a transaction that is rolled back
+	tx, err := d.Begin()
+	s.Require().Nil(err)
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.Exec(query, args...)
+		return err
+	}
+
+	count := func(table string) int {
+		var n int
+		s.Require().Nil(tx.QueryRow("select count(*) from " + table).Scan(&n))
+		return n
+	}
+
+	s.Require().Nil(exec("create role xtenancy_rls_test nologin"))
+	s.Require().Nil(exec("grant select, insert, update, delete on all tables in schema public to xtenancy_rls_test"))
+	s.Require().Nil(exec("set local role xtenancy_rls_test"))
+
+	// without a tenant, no tenant-scoped rows are visible
+	s.Assert().Equal(0, count("member"))
+	s.Assert().Equal(0, count("joinrequest"))
+
+	s.Require().Nil(exec("select set_config('app.tenant_id', $1, true)", "00000000-0000-0000-0000-000000000000"))
+	s.Assert().Equal(1, count("member"))
+	s.Assert().Equal(2, count("joinrequest"))
+
+	// rows of another tenant cannot be written
+	err = exec("savepoint other_tenant")
+	s.Require().Nil(err)
+	err = exec(`insert into member (id, tenant_id, user_id) values ($1, $2, $3)`,
+		"00000000-0000-0000-0000-0000000000aa",
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000003",
+	)
+	s.Assert().True(errors.Is(NewDbError(err), ErrForbidden))
+	s.Require().Nil(exec("rollback to savepoint other_tenant"))
+
+	// the bypass role sees every tenant
+	s.Require().Nil(exec("reset role"))
+	s.Require().Nil(exec("grant xtenancy_rls_bypass to xtenancy_rls_test"))
+	s.Require().Nil(exec("set local role xtenancy_rls_test"))
+	s.Require().Nil(exec("select set_config('app.tenant_id', '', true)"))
+	s.Assert().Equal(2, count("member"))
+	s.Assert().Equal(3, count("joinrequest"))
+}
+
+func (s *StoreTestSuite) TestAdminRole() {
+	var role string
+	var superuser string
+
+	s.Require().Nil(s.Store.sess.SelectBySql("select current_user").LoadOne(&role))
+	s.Require().Nil(s.Store.sess.SelectBySql("select current_setting('is_superuser')").LoadOne(&superuser))
+	s.Assert().Equal("xtenancy_rls_bypass", role)
+	s.Assert().Equal("off", superuser)
+
+	// cross-tenant operations see the rows of every tenant
+	members, err := s.Store.GetMembersByUserId("00000000-0000-0000-0000-000000000000")
+	s.Assert().Nil(err)
+	s.Assert().NotEmpty(members)
+
+	sa, err := s.Store.CreateServiceAccount(&ServiceAccount{TenantId: "00000000-0000-0000-0000-000000000000", Name: "admin"})
+	s.Assert().Nil(err)
+	s.Assert().NotNil(sa)
+}
+
+func (s *StoreTestSuite) TestServingRoleApiKey() {
+	sa, err := s.Store.CreateServiceAccount(&ServiceAccount{
+		TenantId: "00000000-0000-0000-0000-000000000000",
+		Name:     "serving",
+		Roles:    []string{"viewer"},
+	})
+	s.Require().Nil(err)
+
+	k, key, err := s.Store.CreateApiKey(sa.Id)
+	s.Require().Nil(err)
+
+	d := connectServing(s)
+	defer d.Close()
+
+	store, err := NewStore(d, 2)
+	s.Require().Nil(err)
+
+	// the service account is not visible to the serving role before a tenant is set
+	accounts, err := store.GetServiceAccountsByTenantId(sa.TenantId)
+	s.Assert().Nil(err)
+	s.Assert().Empty(accounts)
+
+	identity, err := store.AuthenticateApiKey(key)
+	s.Assert().Nil(err)
+	s.Assert().Equal(&ApiKeyIdentity{
+		ApiKeyId:         k.Id,
+		ServiceAccountId: sa.Id,
+		TenantId:         sa.TenantId,
+		Roles:            []string{"viewer"},
+	}, identity)
+
+	retrieved, _ := s.Store.GetApiKey(k.Id)
+	s.Assert().True(retrieved.LastUsedAt.Valid)
+
+	_, err = store.AuthenticateApiKey(apiKeyScheme + k.Prefix + ".wrong")
+	s.Assert().Equal(ErrInvalidApiKey, err.Error())
+}
+
+func (s *StoreTestSuite) TestSchemaIsolatedTenantLifecycle() {
+	vars, err := LoadEnvVars(envPath)
+	s.Require().Nil(err)
+
+	d := connect(s)
+	defer d.Close()
+
+	store, err := NewStore(d, 2, WithSchemaIsolation(vars))
+	s.Require().Nil(err)
+
+	schemaVersion := func(t *Tenant) (uint, bool) {
+		var version uint
+		query := "select version from " + TenantSchemaName(t.Id) + ".schema_migrations"
+		err := d.QueryRow(query).Scan(&version)
+		return version, err == nil
+	}
+
+	latest, err := readUpMigrations("", "tenant", 0)
+	s.Require().Nil(err)
+
+	// the schema is created and migrated along with the tenant
+	t, err := store.CreateTenant(&Tenant{Name: "isolated", OwnerId: "00000000-0000-0000-0000-000000000000"})
+	s.Require().Nil(err)
+
+	version, ok := schemaVersion(t)
+	s.Assert().True(ok)
+	s.Assert().Equal(latest[len(latest)-1].Version, version)
+
+	// a tenant that fails to be created leaves no schema behind
+	orphan := &Tenant{Name: "orphan", OwnerId: "00000000-0000-0000-0000-777777777777"}
+	_, err = store.CreateTenant(orphan)
+	s.Assert().NotNil(err)
+
+	_, ok = schemaVersion(orphan)
+	s.Assert().False(ok)
+
+	// a tenant that fails to be deleted keeps its schema
+	_, err = store.ForTenant(t.Id).CreateMember(&Member{UserId: "00000000-0000-0000-0000-000000000000"})
+	s.Require().Nil(err)
+	s.Assert().NotNil(store.DeleteTenant(t.Id))
+
+	_, ok = schemaVersion(t)
+	s.Assert().True(ok)
+
+	members, err := store.ForTenant(t.Id).GetMembers()
+	s.Assert().Nil(err)
+	s.Assert().Len(members, 1)
+
+	// clean up
+	_, err = d.Exec("drop schema " + TenantSchemaName(t.Id) + " cascade")
+	s.Assert().Nil(err)
+	s.Assert().Nil(store.DeleteTenant(t.Id))
+}
+
+func (s *StoreTestSuite) TestTenantSchemaName() {
+	s.Assert().Equal("tenant_00000000000000000000000000000005", TenantSchemaName("00000000-0000-0000-0000-000000000005"))
+}
+
+func (s *StoreTestSuite) TestGetUserByEmail() {
+	u, _ := s.Store.GetUserByEmail("b@b.b")
+	s.Assert().Equal("00000000-0000-0000-0000-000000000001", u.Id)
+
+	u, _ = s.Store.GetUserByEmail("nobody@b.b")
+	s.Assert().Nil(u)
+}
+
+func (s *StoreTestSuite) TestInviteAndAccept() {
+	tenantId := "00000000-0000-0000-0000-000000000001"
+
+	// existing user
+	jr, err := s.Store.InviteByEmail(tenantId, "b@b.b")
+	s.Assert().Nil(err)
+	s.Assert().Equal(dbr.NewNullString("00000000-0000-0000-0000-000000000001"), jr.UserId)
+
+	m, err := s.Store.AcceptInvitationMember(jr.Id)
+	s.Assert().Nil(err)
+	s.Assert().Equal(tenantId, m.TenantId)
+	s.Assert().Equal("00000000-0000-0000-0000-000000000001", m.UserId)
+
+	err = s.Store.AcceptInvitation(jr.Id)
+	s.Assert().Equal(ErrInvitationClosed, err.Error())
+
+	_, err = s.Store.InviteByEmail(tenantId, "b@b.b")
+	s.Assert().Equal(ErrAlreadyMember, err.Error())
+
+	// anonymous email
+	jr, err = s.Store.InviteByEmail(tenantId, "anon@b.b")
+	s.Assert().Nil(err)
+	s.Assert().Equal(dbr.NewNullString("anon@b.b"), jr.AnonEmail)
+
+	err = s.Store.AcceptInvitation(jr.Id)
+	s.Assert().Equal(ErrInvitationUserDNE, err.Error())
+
+	s.Assert().Nil(s.Store.RevokeInvitation(jr.Id))
+	s.Assert().Equal(ErrInvitationClosed, s.Store.RevokeInvitation(jr.Id).Error())
+}
+
+func (s *StoreTestSuite) TestGetMemberByUserId() {
+	m, err := s.Store.GetMemberByUserId("00000000-0000-0000-0000-000000000000")
+	s.Assert().Nil(err)
+	s.Assert().Equal("00000000-0000-0000-0000-000000000005", m.TenantId)
+
+	m, err = s.Store.GetMemberByUserId("00000000-0000-0000-0000-777777777777")
+	s.Assert().Nil(err)
+	s.Assert().Nil(m)
+}
+
+func (s *StoreTestSuite) TestTransferTenant() {
+	tenantId := "00000000-0000-0000-0000-000000000001"
+	userId := "00000000-0000-0000-0000-000000000001"
+
+	s.Assert().Nil(s.Store.TransferTenant(tenantId, userId))
+
+	t, _ := s.Store.GetTenant(tenantId)
+	s.Assert().Equal(userId, t.OwnerId)
+
+	m, _ := s.Store.GetTenantMemberByUserId(tenantId, userId)
+	s.Assert().True(m.IsAdmin)
+}
+
+func (s *StoreTestSuite) TestPromoteAndDeactivateMember() {
+	id := "00000000-0000-0000-0000-000000000001"
+
+	_ = s.Store.PromoteMember(id)
+	m, _ := s.Store.GetMember(id)
+	s.Assert().True(m.IsAdmin)
+
+	_ = s.Store.DemoteMember(id)
+	_ = s.Store.DeactivateMember(id)
+	m, _ = s.Store.GetMember(id)
+	s.Assert().False(m.IsAdmin)
+	s.Assert().True(m.IsInactive)
+
+	err := s.Store.PromoteMember("00000000-0000-0000-7777-000000000001")
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+}
+
+func (s *StoreTestSuite) TestExportImportTenant() {
+	a, err := s.Store.ExportTenant("00000000-0000-0000-0000-000000000005")
+	s.Assert().Nil(err)
+	s.Assert().Equal(TenantArchiveVersion, a.Version)
+	s.Assert().Equal("name5", a.Tenant.Name)
+	s.Assert().Len(a.Members, 1)
+	s.Assert().Equal("00000000-0000-0000-0000-000000000000", a.Users[0].Id)
+
+	_, err = s.Store.ImportTenant(a, ImportOptions{OnUserConflict: UserConflictFail})
+	s.Assert().Contains(err.Error(), ErrUserConflict)
+
+	result, err := s.Store.ImportTenant(a, ImportOptions{OnUserConflict: UserConflictReuse})
+	s.Assert().Nil(err)
+	s.Assert().NotEqual(a.Tenant.Id, result.Tenant.Id)
+	s.Assert().False(result.Tenant.ParentId.Valid)
+	s.Assert().Equal([]string{"00000000-0000-0000-0000-000000000000"}, result.ReusedUsers)
+
+	m, _ := s.Store.GetMembersByTenantId(result.Tenant.Id)
+	s.Assert().Len(m, 1)
+	s.Assert().Equal(result.Ids[a.Members[0].Id], m[0].Id)
+	s.Assert().Equal("00000000-0000-0000-0000-000000000000", m[0].UserId)
+
+	// a user whose email address changed is matched by auth id
+	a.Users[0].Email = "changed@a.a"
+
+	_, err = s.Store.ImportTenant(a, ImportOptions{OnUserConflict: UserConflictFail})
+	s.Assert().Equal(ErrUserConflict+": changed@a.a", err.Error())
+
+	result, err = s.Store.ImportTenant(a, ImportOptions{OnUserConflict: UserConflictReuse})
+	s.Assert().Nil(err)
+	s.Assert().Equal([]string{"00000000-0000-0000-0000-000000000000"}, result.ReusedUsers)
+}
+
+func (s *StoreTestSuite) TestExportAndEraseUser() {
+	userId := "00000000-0000-0000-0000-000000000001"
+	tenantId := "00000000-0000-0000-0000-000000000001"
+
+	_, err := s.Store.InviteByEmail(tenantId, "b@b.b")
+	s.Assert().Nil(err)
+
+	// an invitation sent to the email address before the user signed up
+	invitation, err := s.Store.CreateJoinrequest(&Joinrequest{TenantId: tenantId, AnonEmail: dbr.NewNullString("b@b.b")})
+	s.Assert().Nil(err)
+
+	export, err := s.Store.ExportUserData(userId)
+	s.Assert().Nil(err)
+	s.Assert().Equal("b@b.b", export.User.Email)
+	s.Assert().Len(export.Members, 1)
+	s.Assert().Len(export.Joinrequests, 1)
+	s.Assert().Len(export.Invitations, 1)
+
+	s.Assert().Nil(s.Store.EraseUser(userId))
+
+	u, _ := s.Store.GetUser(userId)
+	s.Assert().Equal(ErasedEmail(userId), u.Email)
+	s.Assert().NotEqual(userId, u.AuthId)
+
+	m, _ := s.Store.GetMember("00000000-0000-0000-0000-000000000000")
+	s.Assert().Equal(userId, m.UserId)
+	s.Assert().False(m.Alias.Valid)
+
+	invitations, err := s.Store.GetJoinrequestsByAnonEmail("b@b.b")
+	s.Assert().Nil(err)
+	s.Assert().Empty(invitations)
+
+	invitations, err = s.Store.GetJoinrequestsByAnonEmail(ErasedEmail(userId))
+	s.Assert().Nil(err)
+	s.Assert().Len(invitations, 1)
+	s.Assert().Equal(invitation.Id, invitations[0].Id)
+
+	err = s.Store.EraseUser("00000000-0000-0000-7777-000000000001")
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+}
+
+func (s *StoreTestSuite) TestErrorClassification() {
+	_, err := s.Store.CreateUser(&User{
+		AuthId:    "00000000-0000-0000-0000-000000000009",
+		Email:     "a@a.a",
+		FirstName: "a",
+		LastName:  "a",
+	})
+	s.Assert().True(errors.Is(err, ErrConflict))
+	s.Assert().Equal(ErrEmailTaken, err.Error())
+
+	_, err = s.Store.CreateMember(&Member{
+		TenantId: "00000000-0000-0000-0000-000000000000",
+		UserId:   "00000000-0000-0000-7777-000000000001",
+	})
+	s.Assert().True(errors.Is(err, ErrNotFound))
+	s.Assert().Equal(ErrUserDNE, err.Error())
+
+	err = s.Store.DeleteUser("00000000-0000-0000-0000-000000000000")
+	s.Assert().True(errors.Is(err, ErrPrecondition))
+
+	_, err = s.Store.GetTenant("not a uuid")
+	s.Assert().Equal(CodeValidation, ErrorCode(err))
+
+	err = s.Store.PromoteMember("00000000-0000-0000-7777-000000000001")
+	s.Assert().True(errors.Is(err, ErrNotFound))
+}
+
+func (s *StoreTestSuite) TestUpdateVersion() {
+	id := "00000000-0000-0000-0000-000000000001"
+
+	t, _ := s.Store.GetTenant(id)
+	s.Assert().Equal(1, t.Version)
+
+	s.Assert().Nil(s.Store.UpdateTenant(id, t.Version, &Tenant{Name: "first"}, "Name"))
+
+	// a concurrent edit based on the same read loses
+	err := s.Store.UpdateTenant(id, t.Version, &Tenant{Name: "second"}, "Name")
+	s.Assert().True(errors.Is(err, ErrConflict))
+	s.Assert().Equal(ErrVersionMismatch, err.Error())
+
+	t, _ = s.Store.GetTenant(id)
+	s.Assert().Equal("first", t.Name)
+	s.Assert().Equal(2, t.Version)
+
+	err = s.Store.UpdateTenant("00000000-0000-0000-7777-000000000001", 1, &Tenant{Name: "abc"}, "Name")
+	s.Assert().Equal(ErrResourceDNE, err.Error())
+
+	// internal updates increment the version too
+	m, _ := s.Store.GetMember("00000000-0000-0000-0000-000000000001")
+	s.Assert().Nil(s.Store.PromoteMember(m.Id))
+	err = s.Store.UpdateMember(m.Id, m.Version, &Member{IsInactive: true}, "IsInactive")
+	s.Assert().True(errors.Is(err, ErrConflict))
+}
+
+func (s *StoreTestSuite) TestAuditColumns() {
+	actorId := "00000000-0000-0000-0000-000000000001"
+	store := s.Store.WithActor(actorId)
+
+	t, err := store.CreateTenant(&Tenant{
+		Name:    "audited",
+		OwnerId: "00000000-0000-0000-0000-000000000000",
+	})
+	s.Assert().Nil(err)
+
+	retrieved, _ := s.Store.GetTenant(t.Id)
+	s.Assert().False(retrieved.CreatedAt.IsZero())
+	s.Assert().True(retrieved.CreatedAt.Equal(retrieved.UpdatedAt))
+	s.Assert().Equal(dbr.NewNullString(actorId), retrieved.CreatedBy)
+
+	s.Assert().Nil(s.Store.UpdateTenant(t.Id, AnyVersion, &Tenant{Name: "renamed"}, "Name"))
+
+	updated, _ := s.Store.GetTenant(t.Id)
+	s.Assert().True(updated.CreatedAt.Equal(retrieved.CreatedAt))
+	s.Assert().False(updated.UpdatedAt.Before(retrieved.UpdatedAt))
+	s.Assert().Equal(dbr.NewNullString(actorId), updated.CreatedBy)
+	// an update by an unknown user is not attributed to the previous updater
+	s.Assert().False(updated.UpdatedBy.Valid)
+
+	otherId := "00000000-0000-0000-0000-000000000003"
+	s.Assert().Nil(s.Store.WithActor(otherId).UpdateTenant(t.Id, AnyVersion, &Tenant{Name: "renamed again"}, "Name"))
+
+	updated, _ = s.Store.GetTenant(t.Id)
+	s.Assert().Equal(dbr.NewNullString(actorId), updated.CreatedBy)
+	s.Assert().Equal(dbr.NewNullString(otherId), updated.UpdatedBy)
+}
+
+func (s *StoreTestSuite) TestCountTenantsAndPendingJoinrequests() {
+	count, err := s.Store.CountTenants()
+	s.Assert().Nil(err)
+	s.Assert().Equal(5, count)
+
+	count, err = s.Store.CountPendingJoinrequests()
+	s.Assert().Nil(err)
+	s.Assert().Equal(3, count)
+
+	id := "00000000-0000-0000-0000-000000000000"
+	s.Assert().Nil(s.Store.UpdateJoinrequest(id, AnyVersion, &Joinrequest{IsAccepted: dbr.NewNullBool(false)}, "IsAccepted"))
+
+	id = "00000000-0000-0000-0000-000000000001"
+	expired := dbr.NewNullTime(time.Now().Add(-time.Hour))
+	s.Assert().Nil(s.Store.UpdateJoinrequest(id, AnyVersion, &Joinrequest{ExpiresAt: expired}, "ExpiresAt"))
+
+	count, err = s.Store.CountPendingJoinrequests()
+	s.Assert().Nil(err)
+	s.Assert().Equal(1, count)
+
+	// the role that serves tenants counts the joinrequests of every tenant too
+	d := connectServing(s)
+	defer d.Close()
+
+	store, err := NewStore(d, 2)
+	s.Require().Nil(err)
+
+	count, err = store.CountPendingJoinrequests()
+	s.Assert().Nil(err)
+	s.Assert().Equal(1, count)
+}
+
+func (s *StoreTestSuite) TestSchemaVersion() {
+	expected, err := LatestMigrationVersion("")
+	s.Assert().Nil(err)
+
+	version, dirty, err := SchemaVersion(context.Background(), s.Store.sess.DB)
+	s.Assert().Nil(err)
+	s.Assert().False(dirty)
+	s.Assert().Equal(expected, version)
+}
+
+func (s *StoreTestSuite) TestVerifyTenantMigrations() {
+	files, err := ListTenantMigrations("")
+	s.Require().Nil(err)
+
+	checks, err := VerifyTenantMigrations(s.Store.sess.DB, "", files[0].Version)
+	s.Require().Nil(err)
+	s.Require().Len(checks, len(files))
+
+	for i, check := range checks {
+		s.Assert().Nil(check.Err)
+		s.Assert().Equal(files[i], check.MigrationFile)
+		s.Assert().Equal(i > 0, check.Pending)
+	}
+
+	versions, err := TenantSchemaVersions(context.Background(), s.Store.sess.DB)
+	s.Assert().Nil(err)
+
+	for schema, v := range versions {
+		s.Assert().Equal(files[len(files)-1].Version, v, schema)
+	}
+}
+
+func (s *StoreTestSuite) TestDetectSchemaDrift() {
+	d := s.Store.sess.DB
+
+	latest, err := LatestMigrationVersion("")
+	s.Require().Nil(err)
+
+	drift, err := DetectSchemaDrift(d, "", "public", latest)
+	s.Require().Nil(err)
+	s.Assert().False(drift.HasDrift(), "%v", drift)
+
+	// replay the migrations into a copy of the schema and tamper with its row security
+	scripts, err := ReadUpMigrations("", 0)
+	s.Require().Nil(err)
+
+	tx, err := d.Begin()
+	s.Require().Nil(err)
+
+	_, err = tx.Exec("create schema drift_test; set local search_path to drift_test")
+	s.Require().Nil(err)
+
+	for _, script := range scripts {
+		_, err = tx.Exec(script.Sql)
+		s.Require().Nil(err)
+	}
+
+	_, err = tx.Exec(`
+		drop policy member_rls_bypass on member;
+		alter table joinrequest no force row level security;
+		create or replace function current_tenant_id() returns uuid as
+		$$
+		select null::uuid
+		$$ language sql stable;
+	`)
+	s.Require().Nil(err)
+	s.Require().Nil(tx.Commit())
+
+	defer func() {
+		_, err := d.Exec("drop schema drift_test cascade")
+		s.Assert().Nil(err)
+	}()
+
+	drift, err = DetectSchemaDrift(d, "", "drift_test", latest)
+	s.Require().Nil(err)
+	s.Assert().Equal([]string{
+		"function current_tenant_id() returns uuid language sql stable as select nullif(current_setting('app.tenant_id', true), '')::uuid",
+		"policy member.member_rls_bypass PERMISSIVE ALL to xtenancy_rls_bypass using true with check true",
+		"row security joinrequest forced",
+	}, drift.Missing)
+	s.Assert().Equal([]string{
+		"function current_tenant_id() returns uuid language sql stable as select null::uuid",
+		"row security joinrequest",
+	}, drift.Unexpected)
+}
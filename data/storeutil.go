@@ -1,7 +1,7 @@
 package data
 
 import (
-	"errors"
+	"database/sql"
 	"fmt"
 	"github.com/gocraft/dbr/v2"
 	"github.com/gocraft/dbr/v2/dialect"
@@ -20,14 +20,14 @@ type junction struct {
 
 func (s *Store) validatePartial(resource interface{}, fields ...string) error {
 	if len(fields) == 0 {
-		return errors.New(ErrEmptyFieldMask)
+		return NewError(ErrEmptyFieldMask)
 	}
 
-    return s.validator.StructPartial(resource, fields...)
+    return s.validationError(s.validator.StructPartial(resource, fields...))
 }
 
 func (s *Store) validate(resource interface{}) error {
-    return s.validator.Struct(resource)
+    return s.validationError(s.validator.Struct(resource))
 }
 
 func (s *Store) selectJunction(db *dbr.Session, lookupId interface{}, j junction) *dbr.SelectStmt {
@@ -59,30 +59,57 @@ func quotes(s string) string {
 }
 
 func (s *Store) create(table string, record interface{}, columns []string) error {
+	columns = s.stampCreated(record, columns)
+
 	_, err := s.db.
 		InsertInto(table).
 		Columns(columns...).
 		Record(record).
-		Exec()
+		ExecContext(s.context())
+
+	return newTableDbError(err, table)
+}
 
+// exec runs a statement that the query builders cannot express, e.g. DDL, on the store's session or transaction
+func (s *Store) exec(query string) error {
+	_, err := s.db.UpdateBySql(query).ExecContext(s.context())
 	return err
 }
 
-func (s *Store) update(table string, id interface{}, fields []string, updateSets ...set) error {
+// incrementVersion is the update expression of the version column
+var incrementVersion = dbr.Expr("version + 1")
+
+func (s *Store) update(table string, id interface{}, version int, fields []string, updateSets ...set) error {
+	return s.updateWhere(table, dbr.Eq("id", id), version, fields, updateSets...)
+}
+
+// updateWhere updates the rows matching where, increments their version and records when and by whom they were updated.
+// Unless version is AnyVersion, only a row with that version is updated
+func (s *Store) updateWhere(table string, where dbr.Builder, version int, fields []string, updateSets ...set) error {
 	setMap := makeSetMap(fields, updateSets...)
 
 	if len(setMap) == 0 {
-		return errors.New("update setMap contains zero fields")
+		return NewError(ErrEmptyFieldMask)
+	}
+
+	for col, val := range s.updateStamp() {
+		setMap[col] = val
+	}
+
+	cond := where
+
+	if version != AnyVersion {
+		cond = dbr.And(where, dbr.Eq("version", version))
 	}
 
 	result, err := s.db.
 		Update(table).
 		SetMap(setMap).
-		Where("id = ?", id).
-		Exec()
+		Where(cond).
+		ExecContext(s.context())
 
 	if err != nil {
-		return err
+		return newTableDbError(err, table)
 	}
 
 	count, err := result.RowsAffected()
@@ -92,18 +119,48 @@ func (s *Store) update(table string, id interface{}, fields []string, updateSets
 	}
 
 	if count == 0 {
-		return errors.New(ErrResourceDNE)
+		return s.updateMissError(table, where, version)
 	}
 
 	return err
 }
 
+// updateMissError tells apart an update that matched no row because the row does not exist
+// from one that matched no row because the row has another version
+func (s *Store) updateMissError(table string, where dbr.Builder, version int) error {
+	if version == AnyVersion {
+		return NewError(ErrResourceDNE)
+	}
+
+	var count int
+
+	err := s.db.
+		Select("count(*)").
+		From(quotes(table)).
+		Where(where).
+		LoadOneContext(s.context(), &count)
+
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return NewError(ErrResourceDNE)
+	}
+
+	return NewError(ErrVersionMismatch)
+}
+
 func (s *Store) getById(table string, id interface{}, resource interface{}) (interface{}, int, error) {
+	return s.getWhere(table, dbr.Eq("id", id), resource)
+}
+
+func (s *Store) getWhere(table string, where dbr.Builder, resource interface{}) (interface{}, int, error) {
 	count, err := s.db.
 		Select("*").
 		From(quotes(table)).
-		Where("id = ?", id).
-		Load(resource)
+		Where(where).
+		LoadContext(s.context(), resource)
 
 	if err != nil {
 		return nil, 0, err
@@ -117,7 +174,7 @@ func (s *Store) getManyByIds(table string, ids interface{}, resources interface{
 		Select("*").
 		From(quotes(table)).
 		Where("id = any(?)", pq.Array(ids)).
-		Load(&resources)
+		LoadContext(s.context(), &resources)
 
 	if err != nil {
 		return nil, err
@@ -127,10 +184,14 @@ func (s *Store) getManyByIds(table string, ids interface{}, resources interface{
 }
 
 func (s *Store) delete(table string, id interface{}) error {
-	result, err := s.db.DeleteFrom(table).Where("id = ?", id).Exec()
+	return s.deleteWhere(table, dbr.Eq("id", id))
+}
+
+func (s *Store) deleteWhere(table string, where dbr.Builder) error {
+	result, err := s.db.DeleteFrom(table).Where(where).ExecContext(s.context())
 
 	if err != nil {
-		return err
+		return newTableDbError(err, table)
 	}
 
 	count, err := result.RowsAffected()
@@ -140,18 +201,66 @@ func (s *Store) delete(table string, id interface{}) error {
 	}
 
 	if count == 0 {
-		return errors.New(ErrResourceDNE)
+		return NewError(ErrResourceDNE)
 	}
 
 	return err
 }
 
+// transaction runs fn against a Store bound to a single transaction.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+// If the Store is already bound to a transaction, fn joins it
+func (s *Store) transaction(fn func(s *Store) error) error {
+	return s.transactionWithOptions(nil, fn)
+}
+
+// snapshot runs fn against a Store bound to a read-only repeatable read transaction,
+// so that all of its reads see the database as it was at the first one.
+// If the Store is already bound to a transaction, fn joins it
+func (s *Store) snapshot(fn func(s *Store) error) error {
+	return s.transactionWithOptions(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fn)
+}
+
+func (s *Store) transactionWithOptions(opts *sql.TxOptions, fn func(s *Store) error) error {
+	if _, ok := s.db.(*dbr.Tx); ok {
+		return fn(s)
+	}
+
+	tx, err := s.sess.BeginTx(s.context(), opts)
+
+	if err != nil {
+		return NewDbError(err)
+	}
+
+	defer tx.RollbackUnlessCommitted()
+
+	err = fn(&Store{
+		sess:        s.sess,
+		db:          tx,
+		validator:   s.validator,
+		translators: s.translators,
+		isolation:   s.isolation,
+		vars:        s.vars,
+		actor:       s.actor,
+		receiver:    s.receiver,
+		observer:    s.observer,
+		tracer:      s.tracer,
+		ctx:         s.ctx,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return NewDbError(tx.Commit())
+}
+
 func (s *Store) unlink(junctionTable string, pk1 string, id1 interface{}, pk2 string, id2 interface{}) error {
 	result, err := s.db.
 		InsertInto(junctionTable).
 		Pair(pk1, id1).
 		Pair(pk2, id2).
-		Exec()
+		ExecContext(s.context())
 
 	if err != nil {
 		return err
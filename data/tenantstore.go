@@ -0,0 +1,295 @@
+package data
+
+import (
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/gocraft/dbr/v2"
+	"github.com/lib/pq"
+)
+
+// TenantStore is a view of a Store whose operations are confined to a single tenant.
+// Each operation constrains its query by tenant id and runs in a transaction that sets app.tenant_id,
+// so that the row level security policies of the database reject rows of other tenants as well.
+// The policies fail closed: a Store that is not confined to a tenant sees no tenant-scoped rows,
+// unless its connections assume a role granted xtenancy_rls_bypass, as those of the admin commands do, see OpenAdminDb.
+// With schema isolation, the transaction's search_path is also switched to the tenant's schema
+type TenantStore struct {
+	store    *Store
+	tenantId string
+}
+
+// ForTenant returns a TenantStore confined to a tenant.
+// If the store is bound to a context with a logger, e.g. of a request, its query logs are tagged with the tenant id
+func (s *Store) ForTenant(tenantId string) *TenantStore {
+	if _, ok := logging.LoggerFromContext(s.context()); ok {
+		s = s.WithContext(logging.WithTenant(s.context(), tenantId))
+	}
+
+	return &TenantStore{
+		store:    s,
+		tenantId: tenantId,
+	}
+}
+
+// TenantId returns the id of the tenant the store is confined to
+func (ts *TenantStore) TenantId() string {
+	return ts.tenantId
+}
+
+// observe observes an operation of the tenant store, see Store.observe
+func (ts *TenantStore) observe(op string) (*TenantStore, func(err *error)) {
+	s, end := ts.store.observe(op)
+	return &TenantStore{store: s, tenantId: ts.tenantId}, end
+}
+
+func (ts *TenantStore) transaction(fn func(s *Store) error) error {
+	return ts.store.transaction(func(s *Store) error {
+		var setting string
+
+		_, err := s.db.
+			SelectBySql("select set_config('app.tenant_id', ?, true)", ts.tenantId).
+			LoadContext(s.context(), &setting)
+
+		if err != nil {
+			return NewDbError(err)
+		}
+
+		if s.isolation == IsolationSchema {
+			searchPath := pq.QuoteIdentifier(TenantSchemaName(ts.tenantId)) + ", public"
+
+			_, err := s.db.
+				SelectBySql("select set_config('search_path', ?, true)", searchPath).
+				LoadContext(s.context(), &setting)
+
+			if err != nil {
+				return NewDbError(err)
+			}
+		}
+
+		return fn(s)
+	})
+}
+
+func (ts *TenantStore) inTenant(id string) dbr.Builder {
+	return dbr.And(
+		dbr.Eq("id", id),
+		dbr.Eq("tenant_id", ts.tenantId),
+	)
+}
+
+// GetTenant gets the tenant
+func (ts *TenantStore) GetTenant() (_ *Tenant, err error) {
+	ts, end := ts.observe("TenantStore.GetTenant")
+	defer end(&err)
+
+	var t *Tenant
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		t, err = s.GetTenant(ts.tenantId)
+		return err
+	})
+
+	return t, err
+}
+
+// GetMembers gets the members of the tenant
+func (ts *TenantStore) GetMembers() (_ []*Member, err error) {
+	ts, end := ts.observe("TenantStore.GetMembers")
+	defer end(&err)
+
+	var m []*Member
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		m, err = s.GetMembersByTenantId(ts.tenantId)
+		return err
+	})
+
+	return m, err
+}
+
+// GetMember gets a member of the tenant by id
+func (ts *TenantStore) GetMember(id string) (_ *Member, err error) {
+	ts, end := ts.observe("TenantStore.GetMember")
+	defer end(&err)
+
+	var m *Member
+
+	err = ts.transaction(func(s *Store) error {
+		retrieved, count, err := s.getWhere("member", ts.inTenant(id), &Member{})
+
+		if err != nil {
+			return NewDbError(err)
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		m = retrieved.(*Member)
+		return nil
+	})
+
+	return m, err
+}
+
+// CreateMember creates a new member of the tenant
+func (ts *TenantStore) CreateMember(m *Member) (_ *Member, err error) {
+	ts, end := ts.observe("TenantStore.CreateMember")
+	defer end(&err)
+
+	m.TenantId = ts.tenantId
+
+	var created *Member
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		created, err = s.CreateMember(m)
+		return err
+	})
+
+	return created, err
+}
+
+// UpdateMember updates an existing member of the tenant.
+// The variadic "fields" arg should contain the field names that should be updated.
+// A member cannot be moved to another tenant
+func (ts *TenantStore) UpdateMember(id string, version int, m *Member, fields ...string) (err error) {
+	ts, end := ts.observe("TenantStore.UpdateMember")
+	defer end(&err)
+
+	return ts.transaction(func(s *Store) error {
+		if err := s.validatePartial(m, fields...); err != nil {
+			return err
+		}
+
+		err := s.updateWhere("member", ts.inTenant(id), version, fields,
+			set{"Alias", "alias", m.Alias},
+			set{"IsAdmin", "is_admin", m.IsAdmin},
+			set{"IsInactive", "is_inactive", m.IsInactive},
+		)
+
+		return NewDbError(err)
+	})
+}
+
+// DeleteMember deletes a member of the tenant
+func (ts *TenantStore) DeleteMember(id string) (err error) {
+	ts, end := ts.observe("TenantStore.DeleteMember")
+	defer end(&err)
+
+	return ts.transaction(func(s *Store) error {
+		err := s.deleteWhere("member", ts.inTenant(id))
+		return NewDbError(err)
+	})
+}
+
+// GetJoinrequests gets the joinrequests of the tenant
+func (ts *TenantStore) GetJoinrequests() (_ []*Joinrequest, err error) {
+	ts, end := ts.observe("TenantStore.GetJoinrequests")
+	defer end(&err)
+
+	var jr []*Joinrequest
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		jr, err = s.GetJoinrequestsByTenantId(ts.tenantId)
+		return err
+	})
+
+	return jr, err
+}
+
+// GetJoinrequest gets a joinrequest of the tenant by id
+func (ts *TenantStore) GetJoinrequest(id string) (_ *Joinrequest, err error) {
+	ts, end := ts.observe("TenantStore.GetJoinrequest")
+	defer end(&err)
+
+	var jr *Joinrequest
+
+	err = ts.transaction(func(s *Store) error {
+		retrieved, count, err := s.getWhere("joinrequest", ts.inTenant(id), &Joinrequest{})
+
+		if err != nil {
+			return NewDbError(err)
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		jr = retrieved.(*Joinrequest)
+		return nil
+	})
+
+	return jr, err
+}
+
+// CreateJoinrequest creates a new joinrequest to the tenant
+func (ts *TenantStore) CreateJoinrequest(jr *Joinrequest) (_ *Joinrequest, err error) {
+	ts, end := ts.observe("TenantStore.CreateJoinrequest")
+	defer end(&err)
+
+	jr.TenantId = ts.tenantId
+
+	var created *Joinrequest
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		created, err = s.CreateJoinrequest(jr)
+		return err
+	})
+
+	return created, err
+}
+
+// UpdateJoinrequest updates an existing joinrequest of the tenant.
+// The variadic "fields" arg should contain the field names that should be updated.
+// A joinrequest cannot be moved to another tenant
+func (ts *TenantStore) UpdateJoinrequest(id string, version int, jr *Joinrequest, fields ...string) (err error) {
+	ts, end := ts.observe("TenantStore.UpdateJoinrequest")
+	defer end(&err)
+
+	return ts.transaction(func(s *Store) error {
+		if err := s.validatePartial(jr, fields...); err != nil {
+			return err
+		}
+
+		err := s.updateWhere("joinrequest", ts.inTenant(id), version, fields,
+			set{"UserId", "user_id", jr.UserId},
+			set{"AnonEmail", "anon_email", jr.AnonEmail},
+			set{"IsAccepted", "is_accepted", jr.IsAccepted},
+			set{"IsFromUser", "is_from_user", jr.IsFromUser},
+			set{"ExpiresAt", "expires_at", jr.ExpiresAt},
+		)
+
+		return NewDbError(err)
+	})
+}
+
+// DeleteJoinrequest deletes a joinrequest of the tenant
+func (ts *TenantStore) DeleteJoinrequest(id string) (err error) {
+	ts, end := ts.observe("TenantStore.DeleteJoinrequest")
+	defer end(&err)
+
+	return ts.transaction(func(s *Store) error {
+		err := s.deleteWhere("joinrequest", ts.inTenant(id))
+		return NewDbError(err)
+	})
+}
+
+// GetServiceAccounts gets the service accounts of the tenant
+func (ts *TenantStore) GetServiceAccounts() (_ []*ServiceAccount, err error) {
+	ts, end := ts.observe("TenantStore.GetServiceAccounts")
+	defer end(&err)
+
+	var sa []*ServiceAccount
+
+	err = ts.transaction(func(s *Store) error {
+		var err error
+		sa, err = s.GetServiceAccountsByTenantId(ts.tenantId)
+		return err
+	})
+
+	return sa, err
+}
@@ -0,0 +1,7 @@
+create table widget
+(
+    id       uuid primary key,
+    owner_id uuid not null
+);
+
+create index tenant_name_idx on tenant (name);
@@ -0,0 +1 @@
+drop index widget_owner_id_idx;
@@ -0,0 +1,3 @@
+-- the table is empty when it is indexed
+-- lint:ignore blocking-index
+create index widget_owner_id_idx on widget (owner_id);
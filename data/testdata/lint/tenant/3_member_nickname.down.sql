@@ -0,0 +1 @@
+alter table member drop column nickname;
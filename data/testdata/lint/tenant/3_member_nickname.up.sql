@@ -0,0 +1,2 @@
+alter table member
+    add column nickname varchar(255) not null;
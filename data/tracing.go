@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/gocraft/dbr/v2"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// tracerName is the instrumentation name of the spans of a Store
+const tracerName = "github.com/brietsparks/xtenancy/data"
+
+// WithTracerProvider makes a Store trace its operations and the statements they run
+func WithTracerProvider(tp trace.TracerProvider) StoreOption {
+	return func(s *Store) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithContext returns a copy of the store that runs its statements in ctx, e.g. the context of an inbound request,
+// so that they are canceled with it and its operations and statements are traced as descendants of the span in ctx.
+// The actor of ctx, if any, becomes the store's actor, and the logger of ctx, if any, logs the store's failed and slow queries
+func (s *Store) WithContext(ctx context.Context) *Store {
+	c := *s
+	c.ctx = ctx
+
+	if userId, ok := ActorFromContext(ctx); ok {
+		c.actor = dbr.NewNullString(userId)
+	}
+
+	r, ok := s.receiver.(*QueryReceiver)
+	l, hasLogger := logging.LoggerFromContext(ctx)
+
+	if !ok || !hasLogger {
+		return &c
+	}
+
+	c.receiver = r.withLogger(l)
+	c.sess = s.sess.Connection.NewSession(c.sessionReceiver())
+
+	// a store bound to a transaction keeps running its statements in the transaction
+	if _, ok := s.db.(*dbr.Tx); !ok {
+		c.db = c.sess
+	}
+
+	return &c
+}
+
+// context returns the context of the store's statements. A store that is not bound to a context uses the background
+func (s *Store) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
+// sessionReceiver returns the receiver of the query events of the store's session,
+// which also traces the statements if the store is traced
+func (s *Store) sessionReceiver() dbr.EventReceiver {
+	if s.tracer == nil {
+		return s.receiver
+	}
+
+	return &tracingReceiver{
+		EventReceiver: s.receiver,
+		tracer:        s.tracer,
+	}
+}
+
+// startSpan starts the span of an operation and returns a copy of the store whose statements are traced as its children.
+// The returned function ends the span with the operation's error
+func (s *Store) startSpan(op string) (*Store, func(err error)) {
+	if s.tracer == nil {
+		return s, func(err error) {}
+	}
+
+	ctx, span := s.tracer.Start(s.context(), op)
+	c := *s
+	c.ctx = ctx
+
+	return &c, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, string(ErrorCode(err)))
+		}
+
+		span.End()
+	}
+}
+
+// tracingReceiver is a dbr.TracingEventReceiver that traces each statement as a child of the current operation
+// and passes the other query events on to the receiver it embeds
+type tracingReceiver struct {
+	dbr.EventReceiver
+	tracer trace.Tracer
+}
+
+// SpanStart implements dbr.TracingEventReceiver. ctx is the context the store ran the statement in
+func (r *tracingReceiver) SpanStart(ctx context.Context, eventName string, query string) context.Context {
+	ctx, _ = r.tracer.Start(ctx, statementName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBStatementKey.String(sanitizeSql(query)),
+		),
+	)
+
+	return ctx
+}
+
+// SpanError implements dbr.TracingEventReceiver
+func (r *tracingReceiver) SpanError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, string(ErrorCode(NewDbError(err))))
+}
+
+// SpanFinish implements dbr.TracingEventReceiver
+func (r *tracingReceiver) SpanFinish(ctx context.Context) {
+	trace.SpanFromContext(ctx).End()
+}
+
+// sanitizeSql replaces the literals of a query with placeholders, like the statements printed by dumpStmt.
+// dbr interpolates the values of a statement before it reports the query, so they are stripped again
+// to keep values such as emails out of traces
+func sanitizeSql(query string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		prev := byte(0)
+
+		if i > 0 {
+			prev = query[i-1]
+		}
+
+		switch {
+		case c == '"':
+			j := skipQuoted(query, i+1, '"', false)
+			b.WriteString(query[i:j])
+			i = j
+		case c == '\'':
+			i = skipQuoted(query, i+1, '\'', false)
+			b.WriteByte('?')
+		case (c == 'E' || c == 'e') && i+1 < len(query) && query[i+1] == '\'' && !isIdentByte(prev):
+			i = skipQuoted(query, i+2, '\'', true)
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && !isIdentByte(prev):
+			for i < len(query) && (isIdentByte(query[i]) || query[i] == '.') {
+				i++
+			}
+
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// skipQuoted returns the index after the closing quote of a quoted string or identifier that starts at i.
+// A doubled quote is part of the string, as is any escaped character if backslash escapes apply
+func skipQuoted(s string, i int, quote byte, escapes bool) int {
+	for i < len(s) {
+		switch {
+		case escapes && s[i] == '\\':
+			i += 2
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i += 2
+		case s[i] == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+
+	return len(s)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/gocraft/dbr/v2"
+	"github.com/gocraft/dbr/v2/dialect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestSanitizeSql(t *testing.T) {
+	assert.Equal(t,
+		`SELECT * FROM "user" WHERE (email = ?) AND (version = ?) LIMIT ?`,
+		sanitizeSql(`SELECT * FROM "user" WHERE (email = 'o''neil@a.a') AND (version = 3) LIMIT 1`),
+	)
+	assert.Equal(t,
+		`SELECT * FROM "tenant_0a1b".member WHERE (id = ?) AND (secret_hash = ?) AND (created_at > ?)`,
+		sanitizeSql(`SELECT * FROM "tenant_0a1b".member WHERE (id = '00000000-0000-0000-0000-000000000000') AND (secret_hash = E'\\x0a') AND (created_at > '2026-10-18 12:00:00.000000')`),
+	)
+	assert.Equal(t, `UPDATE api_key SET "version" = version + ?, "roles" = $1`, sanitizeSql(`UPDATE api_key SET "version" = version + 1, "roles" = $1`))
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// the connection is never opened, the statement events are reported by hand
+	d, err := sql.Open("postgres", "")
+	assert.Nil(t, err)
+	defer d.Close()
+
+	s := &Store{receiver: &dbr.NullEventReceiver{}}
+	WithTracerProvider(tp)(s)
+	conn := &dbr.Connection{DB: d, EventReceiver: s.sessionReceiver(), Dialect: dialect.PostgreSQL}
+	s.sess = conn.NewSession(nil)
+	s.db = s.sess
+
+	ctx, request := tp.Tracer("test").Start(context.Background(), "request")
+	traced := s.WithContext(ctx)
+
+	// dbr passes the context a statement runs in to the receiver
+	getUser := func() (err error) {
+		s, end := traced.observe("GetUser")
+		defer end(&err)
+
+		r := s.sess.EventReceiver.(dbr.TracingEventReceiver)
+		ctx := r.SpanStart(s.context(), "dbr.select", `SELECT * FROM "user" WHERE (email = 'a@a.a')`)
+		r.SpanFinish(ctx)
+
+		return NewError(ErrResourceDNE)
+	}
+
+	assert.NotNil(t, getUser())
+	request.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 3)
+
+	statement, op, root := spans[0], spans[1], spans[2]
+	assert.Equal(t, "select user", statement.Name)
+	assert.Equal(t, "GetUser", op.Name)
+	assert.Equal(t, "request", root.Name)
+
+	assert.Equal(t, op.SpanContext.SpanID(), statement.Parent.SpanID())
+	assert.Equal(t, root.SpanContext.SpanID(), op.Parent.SpanID())
+	assert.Contains(t, statement.Attributes, attribute.String("db.statement", `SELECT * FROM "user" WHERE (email = ?)`))
+	assert.Equal(t, string(CodeNotFound), op.Status.Description)
+
+	// the operation traces a copy, the store stays bound to the request
+	assert.Equal(t, ctx, traced.context())
+
+	// a store that is not bound to a context starts root spans
+	_, end := s.startSpan("GetTenants")
+	end(nil)
+	assert.False(t, exporter.GetSpans()[3].Parent.IsValid())
+}
+
+func TestContextLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	r, err := NewQueryReceiver(ReceiverOptions{Logger: logger, Registerer: prometheus.NewRegistry()})
+	assert.Nil(t, err)
+
+	d, err := sql.Open("postgres", "")
+	assert.Nil(t, err)
+	defer d.Close()
+
+	s := &Store{receiver: r}
+	conn := &dbr.Connection{DB: d, EventReceiver: s.sessionReceiver(), Dialect: dialect.PostgreSQL}
+	s.sess = conn.NewSession(nil)
+	s.db = s.sess
+
+	// a store that is not bound to a request logs to the receiver's logger
+	assert.Same(t, s, s.ForTenant("t").store)
+
+	ctx := logging.WithLogger(context.Background(), logger.WithField(logging.FieldRequestId, "r"))
+	ts := s.WithContext(ctx).ForTenant("t")
+
+	failed := ts.store.sess.EventReceiver.EventErrKv("dbr.select.load.query", errors.New("failed"), map[string]string{"sql": "SELECT * FROM member"})
+	assert.NotNil(t, failed)
+	assert.Equal(t, "r", hook.LastEntry().Data[logging.FieldRequestId])
+	assert.Equal(t, "t", hook.LastEntry().Data[logging.FieldTenantId])
+
+	// the store itself keeps logging to the receiver's logger
+	assert.Same(t, r, s.receiver)
+}
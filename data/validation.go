@@ -0,0 +1,154 @@
+package data
+
+import (
+	"fmt"
+	"github.com/gocraft/dbr/v2"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	entranslations "gopkg.in/go-playground/validator.v9/translations/en"
+	frtranslations "gopkg.in/go-playground/validator.v9/translations/fr"
+	"reflect"
+	"strings"
+)
+
+// locales of validation messages
+const LocaleEn = "en"
+const LocaleFr = "fr"
+
+// DefaultLocale is the locale of validation messages when none or an unsupported one is requested
+const DefaultLocale = LocaleEn
+
+// FieldError is a field that failed validation
+type FieldError struct {
+	// Field is the json path of the field, e.g. "email"
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, e.g. "required"
+	Rule string `json:"rule"`
+	// Param is the parameter of the rule, if any
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the fields of a resource that failed validation.
+// It is returned wrapped in an Error with CodeValidation, use errors.As to get it
+type ValidationError struct {
+	errs        validator.ValidationErrors
+	translators *ut.UniversalTranslator
+}
+
+// Fields returns the fields that failed validation with messages in the default locale
+func (e *ValidationError) Fields() []FieldError {
+	return e.Translate(DefaultLocale)
+}
+
+// Translate returns the fields that failed validation with messages in a locale.
+// Unsupported locales fall back to DefaultLocale
+func (e *ValidationError) Translate(locale string) []FieldError {
+	trans, ok := e.translators.GetTranslator(locale)
+
+	if !ok {
+		trans, _ = e.translators.GetTranslator(DefaultLocale)
+	}
+
+	fields := make([]FieldError, len(e.errs))
+
+	for i, fe := range e.errs {
+		fields[i] = FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fe.Translate(trans),
+		}
+	}
+
+	return fields
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+
+	for _, f := range e.Fields() {
+		messages = append(messages, f.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// validationError classifies a validator error as a validation error
+func (s *Store) validationError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	errs, ok := err.(validator.ValidationErrors)
+
+	if !ok {
+		return &Error{Code: CodeValidation, Msg: err.Error(), Err: err}
+	}
+
+	ve := &ValidationError{
+		errs:        errs,
+		translators: s.translators,
+	}
+
+	return &Error{Code: CodeValidation, Msg: ve.Error(), Err: ve}
+}
+
+// newValidator returns a validator that names fields by their json tags and validates nullable columns by their value,
+// and the translators of its messages
+func newValidator() (*validator.Validate, *ut.UniversalTranslator, error) {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonFieldName)
+	v.RegisterCustomTypeFunc(nullStringValue, dbr.NullString{})
+
+	translators := ut.New(en.New(), en.New(), fr.New())
+
+	registrations := map[string]func(v *validator.Validate, trans ut.Translator) error{
+		LocaleEn: entranslations.RegisterDefaultTranslations,
+		LocaleFr: frtranslations.RegisterDefaultTranslations,
+	}
+
+	for locale, register := range registrations {
+		trans, _ := translators.GetTranslator(locale)
+
+		if err := register(v, trans); err != nil {
+			return nil, nil, fmt.Errorf("failed to register %s validation messages: %w", locale, err)
+		}
+	}
+
+	return v, translators, nil
+}
+
+// jsonFieldName names a field by its json tag, falling back to its Go name
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+// nullStringValue validates a dbr.NullString as its string, or as a missing value if it is NULL,
+// so that the rules of a nullable column apply to its value rather than to the struct that wraps it
+func nullStringValue(field reflect.Value) interface{} {
+	if ns, ok := field.Interface().(dbr.NullString); ok && ns.Valid {
+		return ns.String
+	}
+
+	return nil
+}
+
+// fieldPath returns the json path of a field error, without the name of the validated struct
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+
+	if idx := strings.Index(ns, "."); idx >= 0 {
+		return ns[idx+1:]
+	}
+
+	return ns
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"errors"
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidationError(t *testing.T) {
+	v, translators, err := newValidator()
+	assert.Nil(t, err)
+
+	s := &Store{validator: v, translators: translators}
+
+	err = s.validate(&User{
+		Id:     "00000000-0000-0000-0000-000000000000",
+		AuthId: "not a uuid",
+		Email:  "",
+	})
+
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	var ve *ValidationError
+	assert.True(t, errors.As(err, &ve))
+
+	assert.Equal(t, []FieldError{
+		{Field: "authId", Rule: "uuid", Message: "authId must be a valid UUID"},
+		{Field: "email", Rule: "email", Message: "email must be a valid email address"},
+	}, ve.Fields())
+	assert.Equal(t, "authId must be a valid UUID; email must be a valid email address", err.Error())
+
+	fields := ve.Translate(LocaleFr)
+	assert.Equal(t, "authId", fields[0].Field)
+	assert.Equal(t, "authId doit être un UUID valid", fields[0].Message)
+
+	assert.Equal(t, ve.Fields(), ve.Translate("xx"))
+
+	err = s.validatePartial(&Tenant{}, "Name")
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, []FieldError{{Field: "name", Rule: "required", Message: "name is a required field"}}, ve.Fields())
+}
+
+func TestValidateNullString(t *testing.T) {
+	v, translators, err := newValidator()
+	assert.Nil(t, err)
+
+	s := &Store{validator: v, translators: translators}
+
+	tenant := &Tenant{
+		Id:       "00000000-0000-0000-0000-000000000000",
+		Name:     "name",
+		OwnerId:  "00000000-0000-0000-0000-000000000000",
+		ParentId: dbr.NewNullString("not a uuid"),
+	}
+
+	var ve *ValidationError
+	assert.True(t, errors.As(s.validate(tenant), &ve))
+	assert.Equal(t, []FieldError{{Field: "parentId", Rule: "uuid", Message: "parentId must be a valid UUID"}}, ve.Fields())
+
+	// a NULL parent is not validated
+	tenant.ParentId = dbr.NullString{}
+	assert.Nil(t, s.validate(tenant))
+
+	tenant.ParentId = dbr.NewNullString("00000000-0000-0000-0000-000000000001")
+	assert.Nil(t, s.validate(tenant))
+
+	err = s.validatePartial(&Joinrequest{AnonEmail: dbr.NewNullString("not an email")}, "AnonEmail")
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, "anonEmail", ve.Fields()[0].Field)
+	assert.Equal(t, "email", ve.Fields()[0].Rule)
+}
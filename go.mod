@@ -1,21 +1,32 @@
 module github.com/brietsparks/xtenancy
 
-go 1.13
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/davecgh/go-spew v1.1.1
-	github.com/go-playground/universal-translator v0.17.0 // indirect
+	github.com/go-playground/locales v0.13.0
+	github.com/go-playground/universal-translator v0.17.0
 	github.com/gocraft/dbr/v2 v2.6.3
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/golang-migrate/migrate/v4 v4.7.0
-	github.com/google/uuid v1.1.1
+	github.com/google/uuid v1.1.2
 	github.com/jinzhu/gorm v1.9.11
 	github.com/joho/godotenv v1.3.0
 	github.com/leodido/go-urn v1.2.0 // indirect
 	github.com/lib/pq v1.2.0
-	github.com/sirupsen/logrus v1.4.1
-	github.com/stretchr/testify v1.4.0
+	github.com/prometheus/client_golang v1.11.1
+	github.com/sirupsen/logrus v1.6.0
+	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.2
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.3.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.3.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
 	gopkg.in/go-playground/validator.v9 v9.30.2
 	gopkg.in/guregu/null.v3 v3.4.0
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/testfixtures.v2 v2.6.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,132 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/golang-migrate/migrate/v4"
+	"time"
+)
+
+// Check is a named check of a dependency that the service needs to be usable
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Name       string `json:"name"`
+	Ok         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Report is the outcome of a set of checks. It is ok if every check is
+type Report struct {
+	Ok     bool      `json:"ok"`
+	Checks []*Result `json:"checks"`
+}
+
+// Run runs checks one after another and reports their outcome
+func Run(ctx context.Context, checks []Check) *Report {
+	report := &Report{Ok: true}
+
+	for _, c := range checks {
+		start := time.Now()
+		err := c.Run(ctx)
+
+		r := &Result{
+			Name:       c.Name,
+			Ok:         err == nil,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if err != nil {
+			r.Error = err.Error()
+			report.Ok = false
+		}
+
+		report.Checks = append(report.Checks, r)
+	}
+
+	return report
+}
+
+// ReadinessChecks returns the checks that tell whether the service is ready to serve:
+// the database is reachable and its schema is at the version of the latest migration, which is not dirty.
+// With schema isolation, every tenant schema must be at the version of the latest tenant migration too.
+// The migrations embedded in the binary are expected unless vars.MigrationsDir names a migrations directory on disk
+func ReadinessChecks(d *sql.DB, vars data.Vars) []Check {
+	return []Check{
+		{
+			Name: "database",
+			Run:  d.PingContext,
+		},
+		{
+			Name: "schema",
+			Run: func(ctx context.Context) error {
+				return checkSchema(ctx, d, vars)
+			},
+		},
+	}
+}
+
+func checkSchema(ctx context.Context, d *sql.DB, vars data.Vars) error {
+	expected, err := data.LatestMigrationVersion(vars.MigrationsDir)
+
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := data.SchemaVersion(ctx, d)
+
+	if err := checkVersion(version, dirty, err, expected); err != nil {
+		return err
+	}
+
+	if vars.Isolation != data.IsolationSchema {
+		return nil
+	}
+
+	expected, err = data.LatestTenantMigrationVersion(vars.MigrationsDir)
+
+	if err != nil {
+		return err
+	}
+
+	outdated, err := data.OutdatedTenantSchemas(ctx, d, expected)
+
+	if err != nil {
+		return err
+	}
+
+	if len(outdated) > 0 {
+		return fmt.Errorf("%d tenant schema(s) are not at version %d or are dirty, e.g. %s", len(outdated), expected, outdated[0])
+	}
+
+	return nil
+}
+
+// checkVersion checks the outcome of reading a schema version against the expected version
+func checkVersion(version uint, dirty bool, err error, expected uint) error {
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("no migrations applied, expected version %d", expected)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("version %d is dirty, a migration failed halfway", version)
+	}
+
+	if version != expected {
+		return fmt.Errorf("version %d does not match the expected version %d", version, expected)
+	}
+
+	return nil
+}
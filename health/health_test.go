@@ -0,0 +1,33 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	ok := Check{Name: "ok", Run: func(ctx context.Context) error { return nil }}
+	down := Check{Name: "down", Run: func(ctx context.Context) error { return errors.New("connection refused") }}
+
+	report := Run(context.Background(), []Check{ok})
+	assert.True(t, report.Ok)
+	assert.Len(t, report.Checks, 1)
+	assert.True(t, report.Checks[0].Ok)
+
+	report = Run(context.Background(), []Check{ok, down})
+	assert.False(t, report.Ok)
+	assert.Equal(t, "down", report.Checks[1].Name)
+	assert.False(t, report.Checks[1].Ok)
+	assert.Equal(t, "connection refused", report.Checks[1].Error)
+}
+
+func TestCheckVersion(t *testing.T) {
+	assert.Nil(t, checkVersion(3, false, nil, 3))
+	assert.EqualError(t, checkVersion(0, false, migrate.ErrNilVersion, 3), "no migrations applied, expected version 3")
+	assert.EqualError(t, checkVersion(3, true, nil, 3), "version 3 is dirty, a migration failed halfway")
+	assert.EqualError(t, checkVersion(2, false, nil, 3), "version 2 does not match the expected version 3")
+	assert.EqualError(t, checkVersion(0, false, errors.New("connection refused"), 3), "connection refused")
+}
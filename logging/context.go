@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+)
+
+// fields of request-scoped log entries
+const FieldRequestId = "requestId"
+const FieldTenantId = "tenantId"
+const FieldUserId = "userId"
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx that carries a logger
+func WithLogger(ctx context.Context, l logrus.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the standard logger if ctx carries none
+func FromContext(ctx context.Context) logrus.FieldLogger {
+	if l, ok := LoggerFromContext(ctx); ok {
+		return l
+	}
+
+	return logrus.StandardLogger()
+}
+
+// LoggerFromContext returns the logger carried by ctx, if any
+func LoggerFromContext(ctx context.Context) (logrus.FieldLogger, bool) {
+	l, ok := ctx.Value(loggerKey{}).(logrus.FieldLogger)
+	return l, ok
+}
+
+// WithFields returns a copy of ctx whose logger adds fields to its entries
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return WithLogger(ctx, FromContext(ctx).WithFields(fields))
+}
+
+// WithUser returns a copy of ctx whose logger tags its entries with a user id
+func WithUser(ctx context.Context, userId string) context.Context {
+	return WithFields(ctx, logrus.Fields{FieldUserId: userId})
+}
+
+// WithTenant returns a copy of ctx whose logger tags its entries with a tenant id
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return WithFields(ctx, logrus.Fields{FieldTenantId: tenantId})
+}
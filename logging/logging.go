@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
+	"strconv"
+)
+
+// log formats
+const FormatText = "text"
+const FormatJson = "json"
+
+// log destinations
+const OutputStdout = "stdout"
+const OutputStderr = "stderr"
+const OutputFile = "file"
+
+// Config configures the level, format and destination of logs
+type Config struct {
+	Level  logrus.Level
+	Format string
+	Output string
+	// File is the log file written when Output is OutputFile.
+	// It is rotated once it reaches MaxSizeMb, keeping MaxBackups rotated files for up to MaxAgeDays
+	File       string
+	MaxSizeMb  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// DefaultEnv holds the default values of the logging environment variables.
+// Logs go to stderr by default, so that they do not mix with the output of commands
+var DefaultEnv = map[string]string{
+	"LOG_LEVEL":        "info",
+	"LOG_FORMAT":       FormatText,
+	"LOG_OUTPUT":       OutputStderr,
+	"LOG_FILE":         "xtenancy.log",
+	"LOG_MAX_SIZE_MB":  "100",
+	"LOG_MAX_BACKUPS":  "3",
+	"LOG_MAX_AGE_DAYS": "28",
+}
+
+// NewConfig reads the logging environment variables through getenv, falling back to DefaultEnv
+func NewConfig(getenv func(key string) string) (Config, error) {
+	get := func(key string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+
+		return DefaultEnv[key]
+	}
+
+	cfg := Config{
+		Format: get("LOG_FORMAT"),
+		Output: get("LOG_OUTPUT"),
+		File:   get("LOG_FILE"),
+	}
+
+	var err error
+
+	if cfg.Level, err = logrus.ParseLevel(get("LOG_LEVEL")); err != nil {
+		return Config{}, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+
+	if cfg.Format != FormatText && cfg.Format != FormatJson {
+		return Config{}, fmt.Errorf("invalid LOG_FORMAT %q, expected %s or %s", cfg.Format, FormatText, FormatJson)
+	}
+
+	switch cfg.Output {
+	case OutputStdout, OutputStderr, OutputFile:
+	default:
+		return Config{}, fmt.Errorf("invalid LOG_OUTPUT %q, expected %s, %s or %s", cfg.Output, OutputStdout, OutputStderr, OutputFile)
+	}
+
+	ints := []struct {
+		key string
+		val *int
+	}{
+		{"LOG_MAX_SIZE_MB", &cfg.MaxSizeMb},
+		{"LOG_MAX_BACKUPS", &cfg.MaxBackups},
+		{"LOG_MAX_AGE_DAYS", &cfg.MaxAgeDays},
+	}
+
+	for _, i := range ints {
+		if *i.val, err = strconv.Atoi(get(i.key)); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", i.key, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Configure sets the level, format and destination of a logger.
+// The returned function closes the log file, if logs are written to one
+func Configure(l *logrus.Logger, cfg Config) (func() error, error) {
+	closeOutput := func() error { return nil }
+
+	switch cfg.Output {
+	case OutputStdout:
+		l.SetOutput(os.Stdout)
+	case OutputStderr:
+		l.SetOutput(os.Stderr)
+	case OutputFile:
+		file := &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMb,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+		}
+
+		l.SetOutput(file)
+		closeOutput = file.Close
+	default:
+		return nil, fmt.Errorf("unsupported log output %q", cfg.Output)
+	}
+
+	l.SetFormatter(formatter(cfg.Format))
+	l.SetLevel(cfg.Level)
+
+	return closeOutput, nil
+}
+
+func formatter(format string) logrus.Formatter {
+	if format == FormatJson {
+		return &logrus.JSONFormatter{}
+	}
+
+	return &logrus.TextFormatter{}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := NewConfig(getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, Config{
+		Level:      logrus.InfoLevel,
+		Format:     FormatText,
+		Output:     OutputStderr,
+		File:       "xtenancy.log",
+		MaxSizeMb:  100,
+		MaxBackups: 3,
+		MaxAgeDays: 28,
+	}, cfg)
+
+	env["LOG_LEVEL"] = "loud"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+
+	env["LOG_LEVEL"] = "debug"
+	env["LOG_FORMAT"] = "xml"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+
+	env["LOG_FORMAT"] = FormatJson
+	env["LOG_OUTPUT"] = "syslog"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+
+	env["LOG_OUTPUT"] = OutputFile
+	env["LOG_MAX_BACKUPS"] = "some"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+}
+
+func TestConfigureFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "xtenancy.log")
+	l := logrus.New()
+
+	closeLog, err := Configure(l, Config{
+		Level:     logrus.WarnLevel,
+		Format:    FormatJson,
+		Output:    OutputFile,
+		File:      file,
+		MaxSizeMb: 1,
+	})
+	assert.Nil(t, err)
+
+	l.Info("dropped")
+	l.WithField("statement", "select user").Warn("slow query")
+	assert.Nil(t, closeLog())
+
+	b, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "dropped")
+	assert.Contains(t, string(b), `"msg":"slow query"`)
+	assert.Contains(t, string(b), `"statement":"select user"`)
+}
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, logrus.StandardLogger(), FromContext(context.Background()))
+
+	l, hook := test.NewNullLogger()
+	ctx := WithLogger(context.Background(), l.WithField(FieldRequestId, "r"))
+	ctx = WithUser(ctx, "u")
+	ctx = WithTenant(ctx, "t")
+
+	FromContext(ctx).Info("request")
+	assert.Equal(t, logrus.Fields{FieldRequestId: "r", FieldUserId: "u", FieldTenantId: "t"}, hook.LastEntry().Data)
+}
@@ -1,52 +1,136 @@
 package main
 
 import (
+	"fmt"
 	appcli "github.com/brietsparks/xtenancy/cli"
+	"github.com/brietsparks/xtenancy/config"
 	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/brietsparks/xtenancy/tracing"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"log"
 	"os"
+	"strings"
 )
 
 func main() {
-	// log file
-	file, err := os.OpenFile("error.log", os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0755)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	// logger
-	l := logrus.New()
-	l.Out = file
-	l.Formatter = &logrus.JSONFormatter{}
-	l.SetLevel(logrus.ErrorLevel)
-
-	// cli app
+	// cli app. The output of commands goes to stdout and stderr, logs go where the logging configuration says
 	app := cli.NewApp()
-	app.Writer = l.Writer()
+	app.Writer = os.Stdout
+	app.ErrWriter = os.Stderr
 
+	var configFilepath string
 	var envFilepath string
+	var overrides cli.StringSlice
+	var logLevel string
+	var logFormat string
+	var logOutput string
+	var actor string
+	closeLog := func() error { return nil }
 	chDataVars := make(chan data.Vars, 1)
+	chConfig := make(chan *config.Config, 1)
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:        "config, c",
+			Usage:       "Load configuration from a YAML or TOML `FILE`",
+			Destination: &configFilepath,
+		},
 		cli.StringFlag{
 			Name:        "env, e",
-			Usage:       "Load configuration from .env `FILE`",
+			Usage:       "Load configuration from .env `FILE`, by default .env is loaded if it exists",
 			Destination: &envFilepath,
-			Value: ".env",
+		},
+		cli.StringSliceFlag{
+			Name:  "set, s",
+			Usage: "Set a configuration `KEY=VALUE`, overriding every other source",
+			Value: &overrides,
+		},
+		cli.StringFlag{
+			Name:        "log-level",
+			Usage:       "Log entries from `LEVEL` up, one of trace, debug, info, warn or error. Sets LOG_LEVEL",
+			Destination: &logLevel,
+		},
+		cli.StringFlag{
+			Name:        "log-format",
+			Usage:       "Log entries as `FORMAT`, either text or json. Sets LOG_FORMAT",
+			Destination: &logFormat,
+		},
+		cli.StringFlag{
+			Name:        "log-output",
+			Usage:       "Write logs to `OUTPUT`, one of stdout, stderr or file. Sets LOG_OUTPUT",
+			Destination: &logOutput,
+		},
+		cli.StringFlag{
+			Name:        "actor",
+			Usage:       "Record `USER_ID` as the creator or updater of the rows that a command writes. Sets DB_ACTOR",
+			Destination: &actor,
 		},
 	}
 
 	app.Before = func(context *cli.Context) error {
-		vars, err := data.LoadEnvVars(envFilepath)
+		values := map[string]string{}
+
+		for _, kv := range overrides {
+			parts := strings.SplitN(kv, "=", 2)
+
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid --set %q, expected KEY=VALUE", kv)
+			}
+
+			values[parts[0]] = parts[1]
+		}
+
+		flags := map[string]string{
+			"LOG_LEVEL":  logLevel,
+			"LOG_FORMAT": logFormat,
+			"LOG_OUTPUT": logOutput,
+			"DB_ACTOR":   actor,
+		}
+
+		for k, v := range flags {
+			if v != "" {
+				values[k] = v
+			}
+		}
+
+		defaults := map[string]string{}
+
+		for _, env := range []map[string]string{data.DefaultEnv, tracing.DefaultEnv, logging.DefaultEnv} {
+			for k, v := range env {
+				defaults[k] = v
+			}
+		}
+
+		cfg, err := config.Load(config.Options{
+			Defaults:  defaults,
+			File:      configFilepath,
+			EnvFile:   envFilepath,
+			Overrides: values,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		logConfig, err := logging.NewConfig(cfg.Get)
+
+		if err != nil {
+			return err
+		}
+
+		if closeLog, err = logging.Configure(logrus.StandardLogger(), logConfig); err != nil {
+			return err
+		}
+
+		vars, err := data.NewVars(cfg.Get)
 
 		if err != nil {
 			return err
 		}
 
 		chDataVars <- vars
+		chConfig <- cfg
 
 		return nil
 	}
@@ -55,10 +139,22 @@ func main() {
 
 	app.Commands = []cli.Command{
 		migrationCommand,
+		appcli.NewUserCommand("user", chDataVars),
+		appcli.NewTenantCommand("tenant", chDataVars),
+		appcli.NewMemberCommand("member", chDataVars),
+		appcli.NewInviteCommand("invite", chDataVars),
+		appcli.NewSeedCommand("seed", chDataVars),
+		appcli.NewServeCommand("serve", chDataVars, chConfig),
+		appcli.NewDoctorCommand("doctor", chDataVars),
+		appcli.NewConfigCommand("config", chConfig),
+	}
+
+	app.After = func(context *cli.Context) error {
+		return closeLog()
 	}
 
-	err = app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
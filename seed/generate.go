@@ -0,0 +1,249 @@
+package seed
+
+import (
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/gocraft/dbr/v2"
+	"github.com/google/uuid"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Store is the subset of data.Store used to generate synthetic data
+type Store interface {
+	CreateUser(u *data.User) (*data.User, error)
+	CreateTenant(t *data.Tenant) (*data.Tenant, error)
+	CreateMember(m *data.Member) (*data.Member, error)
+	CreateJoinrequest(jr *data.Joinrequest) (*data.Joinrequest, error)
+}
+
+// Options controls the size and shape of generated data
+type Options struct {
+	// Tenants is the number of tenants to generate
+	Tenants int
+	// MeanMembers is the mean number of members per tenant, tenant sizes follow a long tailed distribution
+	MeanMembers float64
+	// SharedUserRatio is the probability that a member is an existing user of another tenant rather than a new user
+	SharedUserRatio float64
+	// AdminRatio is the probability that a member is an admin
+	AdminRatio float64
+	// InactiveRatio is the probability that a member is inactive
+	InactiveRatio float64
+	// MeanInvites is the mean number of open invitations per tenant
+	MeanInvites float64
+	// AnonInviteRatio is the probability that an invitation is addressed to an email address without a user
+	AnonInviteRatio float64
+	// Seed seeds the random generator so that runs are reproducible
+	Seed int64
+}
+
+// DefaultOptions returns options with a realistic distribution of members and invitations
+func DefaultOptions(tenants int) Options {
+	return Options{
+		Tenants:         tenants,
+		MeanMembers:     8,
+		SharedUserRatio: 0.2,
+		AdminRatio:      0.1,
+		InactiveRatio:   0.05,
+		MeanInvites:     2,
+		AnonInviteRatio: 0.5,
+		Seed:            time.Now().UnixNano(),
+	}
+}
+
+// Summary counts the generated records
+type Summary struct {
+	Users        int
+	Tenants      int
+	Members      int
+	Joinrequests int
+}
+
+type generator struct {
+	store   Store
+	opts    Options
+	rnd     *rand.Rand
+	users   []*data.User
+	summary *Summary
+}
+
+// Generate creates synthetic tenants, each with an owner, a long tailed number of members and some open invitations
+func Generate(store Store, opts Options) (*Summary, error) {
+	g := &generator{
+		store:   store,
+		opts:    opts,
+		rnd:     rand.New(rand.NewSource(opts.Seed)),
+		summary: &Summary{},
+	}
+
+	for i := 0; i < opts.Tenants; i++ {
+		if err := g.tenant(i); err != nil {
+			return g.summary, fmt.Errorf("failed to generate tenant %d: %w", i, err)
+		}
+	}
+
+	return g.summary, nil
+}
+
+func (g *generator) tenant(i int) error {
+	owner, err := g.newUser()
+
+	if err != nil {
+		return err
+	}
+
+	t, err := g.store.CreateTenant(&data.Tenant{
+		Name:    fmt.Sprintf("tenant %d", i),
+		OwnerId: owner.Id,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	g.summary.Tenants++
+
+	if err := g.member(t, owner, true, false); err != nil {
+		return err
+	}
+
+	members := map[string]bool{owner.Id: true}
+
+	for n := g.sizeOf(g.opts.MeanMembers - 1); n > 0; n-- {
+		u, err := g.pickUser(members)
+
+		if err != nil {
+			return err
+		}
+
+		members[u.Id] = true
+
+		err = g.member(t, u, g.chance(g.opts.AdminRatio), g.chance(g.opts.InactiveRatio))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for n := g.sizeOf(g.opts.MeanInvites); n > 0; n-- {
+		if err := g.invite(t, members); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) member(t *data.Tenant, u *data.User, isAdmin bool, isInactive bool) error {
+	_, err := g.store.CreateMember(&data.Member{
+		TenantId:   t.Id,
+		UserId:     u.Id,
+		IsAdmin:    isAdmin,
+		IsInactive: isInactive,
+	})
+
+	if err == nil {
+		g.summary.Members++
+	}
+
+	return err
+}
+
+func (g *generator) invite(t *data.Tenant, members map[string]bool) error {
+	jr := &data.Joinrequest{
+		TenantId:   t.Id,
+		IsFromUser: dbr.NewNullBool(g.chance(0.2)),
+	}
+
+	if g.chance(g.opts.AnonInviteRatio) {
+		jr.AnonEmail = dbr.NewNullString(fmt.Sprintf("invitee.%s@example.com", g.token()))
+	} else {
+		u, err := g.pickUser(members)
+
+		if err != nil {
+			return err
+		}
+
+		members[u.Id] = true
+		jr.UserId = dbr.NewNullString(u.Id)
+	}
+
+	// a quarter of the invitations have already expired
+	if g.chance(0.25) {
+		jr.ExpiresAt = dbr.NewNullTime(time.Now().Add(-time.Duration(g.rnd.Intn(30)+1) * 24 * time.Hour))
+	} else {
+		jr.ExpiresAt = dbr.NewNullTime(time.Now().Add(time.Duration(g.rnd.Intn(30)+1) * 24 * time.Hour))
+	}
+
+	_, err := g.store.CreateJoinrequest(jr)
+
+	if err == nil {
+		g.summary.Joinrequests++
+	}
+
+	return err
+}
+
+// pickUser returns an existing user that is not excluded, or a new user
+func (g *generator) pickUser(exclude map[string]bool) (*data.User, error) {
+	if len(g.users) > 0 && g.chance(g.opts.SharedUserRatio) {
+		for attempt := 0; attempt < 3; attempt++ {
+			u := g.users[g.rnd.Intn(len(g.users))]
+
+			if !exclude[u.Id] {
+				return u, nil
+			}
+		}
+	}
+
+	return g.newUser()
+}
+
+func (g *generator) newUser() (*data.User, error) {
+	first := firstNames[g.rnd.Intn(len(firstNames))]
+	last := lastNames[g.rnd.Intn(len(lastNames))]
+
+	// the auth id is drawn from the seeded generator so that a run can be reproduced
+	authId, err := uuid.NewRandomFromReader(g.rnd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := g.store.CreateUser(&data.User{
+		AuthId:    authId.String(),
+		Email:     fmt.Sprintf("%s.%s.%s@example.com", first, last, g.token()),
+		FirstName: first,
+		LastName:  last,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	g.users = append(g.users, u)
+	g.summary.Users++
+
+	return u, nil
+}
+
+// sizeOf draws a non-negative count from an exponential distribution, so that most tenants are small and a few are large
+func (g *generator) sizeOf(mean float64) int {
+	if mean <= 0 {
+		return 0
+	}
+
+	return int(math.Round(g.rnd.ExpFloat64() * mean))
+}
+
+func (g *generator) chance(p float64) bool {
+	return g.rnd.Float64() < p
+}
+
+func (g *generator) token() string {
+	return fmt.Sprintf("%08x", g.rnd.Uint32())
+}
+
+var firstNames = []string{"ada", "alan", "barbara", "claude", "dennis", "edsger", "frances", "grace", "john", "ken", "linus", "margaret", "radia", "tim"}
+var lastNames = []string{"hopper", "knuth", "lamport", "liskov", "lovelace", "perlman", "ritchie", "shannon", "thompson", "torvalds", "turing", "wirth"}
@@ -0,0 +1,98 @@
+package seed
+
+import (
+	"fmt"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeStore struct {
+	users        []*data.User
+	tenants      []*data.Tenant
+	members      []*data.Member
+	joinrequests []*data.Joinrequest
+}
+
+func (f *fakeStore) CreateUser(u *data.User) (*data.User, error) {
+	u.Id = fmt.Sprintf("user%d", len(f.users))
+	f.users = append(f.users, u)
+	return u, nil
+}
+
+func (f *fakeStore) CreateTenant(t *data.Tenant) (*data.Tenant, error) {
+	t.Id = fmt.Sprintf("tenant%d", len(f.tenants))
+	f.tenants = append(f.tenants, t)
+	return t, nil
+}
+
+func (f *fakeStore) CreateMember(m *data.Member) (*data.Member, error) {
+	f.members = append(f.members, m)
+	return m, nil
+}
+
+func (f *fakeStore) CreateJoinrequest(jr *data.Joinrequest) (*data.Joinrequest, error) {
+	f.joinrequests = append(f.joinrequests, jr)
+	return jr, nil
+}
+
+func TestGenerate(t *testing.T) {
+	opts := DefaultOptions(50)
+	opts.Seed = 1
+
+	store := &fakeStore{}
+	summary, err := Generate(store, opts)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50, summary.Tenants)
+	assert.Equal(t, len(store.users), summary.Users)
+	assert.Equal(t, len(store.members), summary.Members)
+	assert.Equal(t, len(store.joinrequests), summary.Joinrequests)
+
+	// every tenant is owned by an admin member and no user is a member of a tenant twice
+	owners := map[string]string{}
+	for _, tenant := range store.tenants {
+		owners[tenant.Id] = tenant.OwnerId
+	}
+
+	seen := map[string]bool{}
+	users := map[string]int{}
+	for _, m := range store.members {
+		key := m.TenantId + "/" + m.UserId
+		assert.False(t, seen[key], key)
+		seen[key] = true
+		users[m.UserId]++
+
+		if owners[m.TenantId] == m.UserId {
+			assert.True(t, m.IsAdmin)
+		}
+	}
+
+	assert.Len(t, seen, len(store.members))
+
+	// some users are shared across tenants
+	shared := 0
+	for _, n := range users {
+		if n > 1 {
+			shared++
+		}
+	}
+	assert.True(t, shared > 0)
+
+	// invitations are addressed either to a user or to an email address
+	for _, jr := range store.joinrequests {
+		assert.NotEqual(t, jr.UserId.Valid, jr.AnonEmail.Valid)
+		assert.True(t, jr.ExpiresAt.Valid)
+	}
+
+	// the same seed generates the same data
+	again := &fakeStore{}
+	_, err = Generate(again, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(store.members), len(again.members))
+	assert.Equal(t, store.users[len(store.users)-1].Email, again.users[len(again.users)-1].Email)
+
+	for i, u := range store.users {
+		assert.Equal(t, u.AuthId, again.users[i].AuthId)
+	}
+}
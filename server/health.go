@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// how long the readiness checks of a request may take altogether
+const readinessTimeout = 5 * time.Second
+
+// healthz reports that the process is alive and serving requests
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz runs the readiness checks and reports 503 Service Unavailable unless all of them pass.
+// The endpoint is not authenticated, so it only reports whether each check passed and the errors are logged instead
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := health.Run(ctx, s.checks)
+	status := http.StatusOK
+
+	if !report.Ok {
+		status = http.StatusServiceUnavailable
+	}
+
+	for _, c := range report.Checks {
+		if c.Ok {
+			continue
+		}
+
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"check": c.Name,
+			"error": c.Error,
+		}).Warn("readiness check failed")
+
+		c.Error = ""
+	}
+
+	writeJson(w, status, report)
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
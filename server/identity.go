@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/brietsparks/xtenancy/auth"
+	"net/http"
+)
+
+// me reports the user that the request is authenticated as.
+// It is served behind Options.Authenticate, which injects the identity into the request context
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	identity, ok := auth.IdentityFromContext(r.Context())
+
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	writeJson(w, http.StatusOK, identity.User)
+}
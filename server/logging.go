@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// probeRoutes are polled by the orchestrator, their requests are only logged at debug level
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// requestIdHeader carries the id of a request, which is generated unless the client or a proxy sets a valid one
+const requestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength caps the length of the request ids that are taken from the client
+const maxRequestIdLength = 128
+
+// validRequestId reports whether a request id set by the client may be logged and echoed as is:
+// it is not too long and only consists of letters, digits and the separators of common id formats
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// log passes a logger that tags entries with the request's id and trace id on in the request's context,
+// and logs each request of a route once it has been served
+func (s *Server) log(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get(requestIdHeader)
+
+		if !validRequestId(requestId) {
+			requestId = uuid.New().String()
+		}
+
+		w.Header().Set(requestIdHeader, requestId)
+
+		fields := logrus.Fields{logging.FieldRequestId: requestId}
+
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields["traceId"] = sc.TraceID().String()
+		}
+
+		ctx := logging.WithLogger(r.Context(), s.logger.WithFields(fields))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"method":     r.Method,
+			"route":      route,
+			"status":     rec.status,
+			"durationMs": time.Since(start).Milliseconds(),
+		})
+
+		if probeRoutes[route] {
+			entry.Debug("request")
+			return
+		}
+
+		entry.Info("request")
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metrics serves the metrics of the registry and the domain metrics, which are read from the store within the request
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	domain := prometheus.NewRegistry()
+
+	if err := domain.Register(newDomainCollector(s.requestStore(r))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	promhttp.HandlerFor(prometheus.Gatherers{s.registry, domain}, promhttp.HandlerOpts{
+		// metrics that fail to collect, e.g. because the database is down, are skipped rather than failing the scrape
+		ErrorHandling: promhttp.ContinueOnError,
+	}).ServeHTTP(w, r)
+}
+
+// instrument measures the duration and status code of the requests of a route
+func (s *Server) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		s.requests.
+			WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder is a ResponseWriter that remembers the status code written to it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// domainCollector reports counts of domain records, read from the store when metrics are scraped
+type domainCollector struct {
+	store   Store
+	tenants *prometheus.Desc
+	pending *prometheus.Desc
+}
+
+func newDomainCollector(store Store) *domainCollector {
+	return &domainCollector{
+		store:   store,
+		tenants: prometheus.NewDesc("xtenancy_tenants", "Number of tenants.", nil, nil),
+		pending: prometheus.NewDesc("xtenancy_pending_joinrequests", "Number of joinrequests that are neither accepted nor declined and have not expired.", nil, nil),
+	}
+}
+
+func (c *domainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tenants
+	ch <- c.pending
+}
+
+func (c *domainCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- gauge(c.tenants, c.store.CountTenants)
+	ch <- gauge(c.pending, c.store.CountPendingJoinrequests)
+}
+
+// gauge reads a count into a gauge. A failed read is reported as an invalid metric
+func gauge(desc *prometheus.Desc, count func() (int, error)) prometheus.Metric {
+	n, err := count()
+
+	if err != nil {
+		return prometheus.NewInvalidMetric(desc, err)
+	}
+
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(n))
+}
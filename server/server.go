@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Store is the part of the data store that the server reads
+type Store interface {
+	CountTenants() (int, error)
+	CountPendingJoinrequests() (int, error)
+}
+
+// contextStore is a Store that can be bound to the context of a request, like data.Store
+type contextStore interface {
+	WithContext(ctx context.Context) *data.Store
+}
+
+// requestStore returns the store bound to the context of a request, so that its statements are canceled with the request
+// and traced within it. A store that cannot be bound is returned as is
+func (s *Server) requestStore(r *http.Request) Store {
+	if cs, ok := s.store.(contextStore); ok {
+		return cs.WithContext(r.Context())
+	}
+
+	return s.store
+}
+
+// Options configures a Server
+type Options struct {
+	// Registry holds the metrics exposed at /metrics. It defaults to a new registry.
+	// Pass the registry that the store's metrics are registered with to expose them too
+	Registry *prometheus.Registry
+	// DB is the database whose connection pool stats are exposed. Pool stats are omitted if it is nil
+	DB *sql.DB
+	// DBName labels the connection pool stats
+	DBName string
+	// TracerProvider traces the requests. Requests are not traced if it is nil
+	TracerProvider trace.TracerProvider
+	// Propagator reads the trace context of a request from its headers. It defaults to W3C trace context
+	Propagator propagation.TextMapPropagator
+	// Logger logs the requests and is the base of the request-scoped loggers. It defaults to the logrus standard logger
+	Logger logrus.FieldLogger
+	// Checks are run by /readyz, e.g. health.ReadinessChecks
+	Checks []health.Check
+	// Authenticate wraps the endpoints that act on behalf of a user, e.g. auth.Authenticator.Middleware.
+	// They are not served if it is nil. The probes and /metrics are never authenticated
+	Authenticate func(http.Handler) http.Handler
+}
+
+// Server serves the http endpoints of the service
+type Server struct {
+	store      Store
+	registry   *prometheus.Registry
+	mux        *http.ServeMux
+	requests   *prometheus.HistogramVec
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	logger     logrus.FieldLogger
+	checks     []health.Check
+}
+
+// New creates a Server and registers its metrics
+func New(store Store, opts Options) (*Server, error) {
+	if opts.Registry == nil {
+		opts.Registry = prometheus.NewRegistry()
+	}
+
+	if opts.TracerProvider == nil {
+		opts.TracerProvider = trace.NewNoopTracerProvider()
+	}
+
+	if opts.Propagator == nil {
+		opts.Propagator = propagation.TraceContext{}
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
+
+	s := &Server{
+		store:    store,
+		registry: opts.Registry,
+		mux:      http.NewServeMux(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "xtenancy_http_request_duration_seconds",
+			Help:    "Duration of http requests by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		tracer:     opts.TracerProvider.Tracer(tracerName),
+		propagator: opts.Propagator,
+		logger:     opts.Logger,
+		checks:     opts.Checks,
+	}
+
+	cs := []prometheus.Collector{
+		s.requests,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	}
+
+	if opts.DB != nil {
+		cs = append(cs, collectors.NewDBStatsCollector(opts.DB, opts.DBName))
+	}
+
+	for _, c := range cs {
+		if err := s.registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	s.handle("/healthz", http.HandlerFunc(s.healthz))
+	s.handle("/readyz", http.HandlerFunc(s.readyz))
+	s.handle("/metrics", http.HandlerFunc(s.metrics))
+
+	if opts.Authenticate != nil {
+		s.handle("/me", opts.Authenticate(http.HandlerFunc(s.me)))
+	}
+
+	return s, nil
+}
+
+// Handler returns the handler that routes requests to the server's endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// handle routes a pattern to a handler whose requests are traced, logged and measured under the pattern as route
+func (s *Server) handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, s.trace(pattern, s.log(pattern, s.instrument(pattern, h))))
+}
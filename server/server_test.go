@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/brietsparks/xtenancy/auth"
+	"github.com/brietsparks/xtenancy/data"
+	"github.com/brietsparks/xtenancy/health"
+	"github.com/brietsparks/xtenancy/logging"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+type fakeStore struct {
+	tenants int
+	pending int
+	err     error
+}
+
+func (f *fakeStore) CountTenants() (int, error) {
+	return f.tenants, f.err
+}
+
+func (f *fakeStore) CountPendingJoinrequests() (int, error) {
+	return f.pending, f.err
+}
+
+func scrape(t *testing.T, h http.Handler) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	assert.Nil(t, err)
+	return w.Code, string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	// the pool is not connected, its stats are all zero
+	d, err := sql.Open("postgres", "")
+	assert.Nil(t, err)
+	defer d.Close()
+
+	registry := prometheus.NewRegistry()
+	srv, err := New(&fakeStore{tenants: 3, pending: 2}, Options{Registry: registry, DB: d, DBName: "test"})
+	assert.Nil(t, err)
+
+	code, body := scrape(t, srv.Handler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "xtenancy_tenants 3\n")
+	assert.Contains(t, body, "xtenancy_pending_joinrequests 2\n")
+	assert.Contains(t, body, `go_sql_open_connections{db_name="test"} 0`)
+
+	// the first scrape is measured once it has been served
+	_, body = scrape(t, srv.Handler())
+	assert.Contains(t, body, `xtenancy_http_request_duration_seconds_count{code="200",method="GET",route="/metrics"} 1`)
+	assert.Equal(t, 1, testutil.CollectAndCount(srv.requests))
+}
+
+func TestMetricsStoreError(t *testing.T) {
+	srv, err := New(&fakeStore{err: errors.New("connection refused")}, Options{})
+	assert.Nil(t, err)
+
+	code, body := scrape(t, srv.Handler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, strings.Contains(body, "xtenancy_tenants"))
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	srv, err := New(&fakeStore{}, Options{TracerProvider: tp})
+	assert.Nil(t, err)
+
+	var handlerSpan trace.SpanContext
+
+	srv.handle("/traced", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/traced", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "GET /traced", span.Name)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.True(t, span.Parent.IsRemote())
+	assert.Equal(t, span.SpanContext, handlerSpan)
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Contains(t, span.Attributes, attribute.Int("http.status_code", http.StatusServiceUnavailable))
+}
+
+// fakeConnector connects to a database that answers every query with a single row holding a count,
+// so that a data.Store runs its statements without a database
+type fakeConnector struct{}
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (c fakeConnector) Driver() driver.Driver                            { return c }
+func (c fakeConnector) Open(name string) (driver.Conn, error)            { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeConn{}, nil }
+func (fakeConn) Commit() error                             { return nil }
+func (fakeConn) Rollback() error                           { return nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+type fakeRows struct{ done bool }
+
+func (r *fakeRows) Columns() []string { return []string{"count"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	dest[0] = int64(3)
+	r.done = true
+	return nil
+}
+
+func TestStoreTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	d := sql.OpenDB(fakeConnector{})
+	defer d.Close()
+
+	// the store holds a connection of its pool from the start
+	store, err := data.NewStore(d, 2, data.WithTracerProvider(tp))
+	assert.Nil(t, err)
+
+	srv, err := New(store, Options{TracerProvider: tp})
+	assert.Nil(t, err)
+
+	_, body := scrape(t, srv.Handler())
+	assert.Contains(t, body, "xtenancy_tenants 3\n")
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+
+	for _, span := range exporter.GetSpans().Snapshots() {
+		if _, ok := spans[span.Name()]; !ok {
+			spans[span.Name()] = span
+		}
+	}
+
+	// the store reads the domain metrics within the request
+	request, op, statement := spans["GET /metrics"], spans["CountTenants"], spans["select tenant"]
+	assert.NotNil(t, request)
+	assert.NotNil(t, op)
+	assert.NotNil(t, statement)
+	assert.Equal(t, request.SpanContext().SpanID(), op.Parent().SpanID())
+	assert.Equal(t, op.SpanContext().SpanID(), statement.Parent().SpanID())
+}
+
+func TestRequestLogging(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	srv, err := New(&fakeStore{}, Options{Logger: logger})
+	assert.Nil(t, err)
+
+	srv.handle("/logged", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(logging.WithTenant(r.Context(), "t")).Info("handled")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/logged", nil)
+	req.Header.Set("X-Request-Id", "r")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, "r", w.Header().Get("X-Request-Id"))
+	assert.Len(t, hook.Entries, 2)
+
+	handled := hook.Entries[0]
+	assert.Equal(t, "handled", handled.Message)
+	assert.Equal(t, logrus.Fields{logging.FieldRequestId: "r", logging.FieldTenantId: "t"}, handled.Data)
+
+	request := hook.Entries[1]
+	assert.Equal(t, "request", request.Message)
+	assert.Equal(t, "r", request.Data[logging.FieldRequestId])
+	assert.Equal(t, "/logged", request.Data["route"])
+	assert.Equal(t, http.StatusNotFound, request.Data["status"])
+
+	// a request without an id gets one
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logged", nil))
+	assert.Len(t, w.Header().Get("X-Request-Id"), 36)
+
+	// so does a request whose id is too long or could forge log lines
+	for _, id := range []string{strings.Repeat("r", 129), "r\nlevel=error msg=forged", `r" injected="x`} {
+		req = httptest.NewRequest(http.MethodGet, "/logged", nil)
+		req.Header.Set("X-Request-Id", id)
+		w = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		assert.Len(t, w.Header().Get("X-Request-Id"), 36)
+	}
+}
+
+func TestValidRequestId(t *testing.T) {
+	assert.True(t, validRequestId("4bf92f35-77b3-4da6-a3ce-929d0e0e4736"))
+	assert.True(t, validRequestId("lb:1a2B_3.c"))
+	assert.True(t, validRequestId(strings.Repeat("r", 128)))
+	assert.False(t, validRequestId(""))
+	assert.False(t, validRequestId(strings.Repeat("r", 129)))
+	assert.False(t, validRequestId("r r"))
+	assert.False(t, validRequestId("r\u00e9"))
+}
+
+func TestHealth(t *testing.T) {
+	var failure error
+	logger, hook := test.NewNullLogger()
+
+	srv, err := New(&fakeStore{}, Options{
+		Logger: logger,
+		Checks: []health.Check{
+			{Name: "database", Run: func(ctx context.Context) error { return failure }},
+		},
+	})
+	assert.Nil(t, err)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status": "ok"}`, body)
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `{"name":"database","ok":true,`)
+
+	failure = errors.New("version 3 is dirty, a migration failed halfway")
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, `"ok":false`)
+
+	// the error is logged rather than disclosed to the unauthenticated client
+	assert.NotContains(t, body, "dirty")
+	failed := hook.LastEntry()
+	assert.Equal(t, "readiness check failed", failed.Message)
+	assert.Equal(t, "database", failed.Data["check"])
+	assert.Equal(t, "version 3 is dirty, a migration failed halfway", failed.Data["error"])
+
+	// failing checks do not make the process look dead
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+type fakeUserStore struct {
+	users map[string]*data.User
+}
+
+func (f *fakeUserStore) GetUserByAuthId(authId string) (*data.User, error) {
+	return f.users[authId], nil
+}
+
+func (f *fakeUserStore) CreateUser(u *data.User) (*data.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestAuthentication(t *testing.T) {
+	user := &data.User{Id: "00000000-0000-0000-0000-000000000000", AuthId: "00000000-0000-0000-0000-000000000001"}
+	authenticator, err := auth.NewAuthenticator(auth.Config{HmacSecret: "secret"}, &fakeUserStore{
+		users: map[string]*data.User{user.AuthId: user},
+	})
+	assert.Nil(t, err)
+
+	srv, err := New(&fakeStore{}, Options{Authenticate: authenticator.Middleware})
+	assert.Nil(t, err)
+
+	get := func(path string, token string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := get("/me", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = get("/me", "not a token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: user.AuthId}).SignedString([]byte("secret"))
+	assert.Nil(t, err)
+
+	code, body := get("/me", token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, user.Id)
+
+	// the probes are not authenticated
+	code, _ = get("/healthz", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// without an authenticator, the authenticated endpoints are not served
+	srv, err = New(&fakeStore{}, Options{})
+	assert.Nil(t, err)
+
+	code, _ = get("/me", token)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// tracerName is the instrumentation name of the spans of the server
+const tracerName = "github.com/brietsparks/xtenancy/server"
+
+// trace traces the requests of a route as children of the trace context in their headers.
+// The span is passed on in the request's context, so that handlers can trace their work within it,
+// e.g. with data.Store.WithContext
+func (s *Server) trace(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := s.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := s.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, r)...),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rec.status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(rec.status, trace.SpanKindServer))
+	})
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"strconv"
+)
+
+// span exporters
+const ExporterNone = "none"
+const ExporterStdout = "stdout"
+const ExporterOtlp = "otlp"
+
+// Config configures where and which spans are exported
+type Config struct {
+	// Exporter is ExporterNone, ExporterStdout or ExporterOtlp
+	Exporter string
+	// OtlpEndpoint is the host:port of the OTLP/HTTP collector
+	OtlpEndpoint string
+	// OtlpInsecure sends spans to the collector over http instead of https
+	OtlpInsecure bool
+	// ServiceName is the service.name of the exported spans
+	ServiceName string
+	// SampleRatio is the fraction of the traces that are sampled, unless the trace's parent decided already
+	SampleRatio float64
+}
+
+// DefaultEnv holds the default values of the tracing environment variables
+var DefaultEnv = map[string]string{
+	"TRACING_EXPORTER":      ExporterNone,
+	"TRACING_OTLP_ENDPOINT": "localhost:4318",
+	"TRACING_OTLP_INSECURE": "false",
+	"TRACING_SERVICE_NAME":  "xtenancy",
+	"TRACING_SAMPLE_RATIO":  "1",
+}
+
+// NewConfig reads the tracing environment variables through getenv, falling back to DefaultEnv
+func NewConfig(getenv func(key string) string) (Config, error) {
+	get := func(key string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+
+		return DefaultEnv[key]
+	}
+
+	cfg := Config{
+		Exporter:     get("TRACING_EXPORTER"),
+		OtlpEndpoint: get("TRACING_OTLP_ENDPOINT"),
+		ServiceName:  get("TRACING_SERVICE_NAME"),
+	}
+
+	switch cfg.Exporter {
+	case ExporterNone, ExporterStdout, ExporterOtlp:
+	default:
+		return Config{}, fmt.Errorf("invalid TRACING_EXPORTER %q, expected %s, %s or %s", cfg.Exporter, ExporterNone, ExporterStdout, ExporterOtlp)
+	}
+
+	var err error
+
+	if cfg.OtlpInsecure, err = strconv.ParseBool(get("TRACING_OTLP_INSECURE")); err != nil {
+		return Config{}, fmt.Errorf("invalid TRACING_OTLP_INSECURE: %w", err)
+	}
+
+	if cfg.SampleRatio, err = strconv.ParseFloat(get("TRACING_SAMPLE_RATIO"), 64); err != nil {
+		return Config{}, fmt.Errorf("invalid TRACING_SAMPLE_RATIO: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// NewProvider creates a tracer provider that exports spans as configured. Spans exported to stdout are written to out.
+// The returned function flushes the spans that were not exported yet and stops the provider
+func NewProvider(ctx context.Context, cfg Config, out io.Writer) (trace.TracerProvider, func(ctx context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case ExporterNone:
+		return trace.NewNoopTracerProvider(), func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	case ExporterOtlp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OtlpEndpoint)}
+
+		if cfg.OtlpInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		err = fmt.Errorf("unsupported span exporter %q", cfg.Exporter)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := newProvider(cfg, exporter)
+
+	return tp, tp.Shutdown, nil
+}
+
+// newProvider creates a tracer provider that batches spans to an exporter
+func newProvider(cfg Config, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+		)),
+	)
+}
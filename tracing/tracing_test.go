@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := NewConfig(getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, Config{
+		Exporter:     ExporterNone,
+		OtlpEndpoint: "localhost:4318",
+		ServiceName:  "xtenancy",
+		SampleRatio:  1,
+	}, cfg)
+
+	env["TRACING_EXPORTER"] = "jaeger"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+
+	env["TRACING_EXPORTER"] = ExporterOtlp
+	env["TRACING_SAMPLE_RATIO"] = "half"
+	_, err = NewConfig(getenv)
+	assert.NotNil(t, err)
+}
+
+func TestNewProvider(t *testing.T) {
+	ctx := context.Background()
+
+	tp, shutdown, err := NewProvider(ctx, Config{Exporter: ExporterNone}, nil)
+	assert.Nil(t, err)
+	_, span := tp.Tracer("test").Start(ctx, "noop")
+	assert.False(t, span.SpanContext().IsValid())
+	assert.Nil(t, shutdown(ctx))
+
+	buf := &bytes.Buffer{}
+	tp, shutdown, err = NewProvider(ctx, Config{Exporter: ExporterStdout, ServiceName: "test", SampleRatio: 1}, buf)
+	assert.Nil(t, err)
+	_, span = tp.Tracer("test").Start(ctx, "exported", trace.WithSpanKind(trace.SpanKindServer))
+	span.End()
+
+	// batched spans are flushed on shutdown
+	assert.Nil(t, shutdown(ctx))
+	assert.Contains(t, buf.String(), `"Name":"exported"`)
+}